@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines surrounding the changes of a hunk
+const diffContext = 3
+
+// edit is a single line of a diff, kind is ' ' for unchanged, '-' for removed and '+' for added lines
+type edit struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the unified diff turning the content before into the content after, which is empty if they are equal
+func unifiedDiff(oldName, newName string, before, after []byte) string {
+	if bytes.Equal(before, after) {
+		return ""
+	}
+	edits := diffLines(splitLines(before), splitLines(after))
+
+	// The line numbers preceding each edit, used for the hunk headers
+	oldLines := make([]int, len(edits)+1)
+	newLines := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldLines[i+1], newLines[i+1] = oldLines[i], newLines[i]
+		if e.kind != '+' {
+			oldLines[i+1]++
+		}
+		if e.kind != '-' {
+			newLines[i+1]++
+		}
+	}
+
+	res := new(strings.Builder)
+	fmt.Fprintf(res, "--- %s\n+++ %s\n", oldName, newName)
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Extend the hunk until the next change is too far away to share the context
+		start, end := i-diffContext, i
+		if start < 0 {
+			start = 0
+		}
+		for end < len(edits) {
+			if edits[end].kind != ' ' {
+				end++
+				continue
+			}
+			unchanged := end
+			for unchanged < len(edits) && edits[unchanged].kind == ' ' {
+				unchanged++
+			}
+			if unchanged == len(edits) || unchanged-end > 2*diffContext {
+				end += diffContext
+				if end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = unchanged
+		}
+
+		fmt.Fprintf(res, "@@ -%s +%s @@\n",
+			hunkRange(oldLines[start], oldLines[end]),
+			hunkRange(newLines[start], newLines[end]),
+		)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(res, "%c%s\n", e.kind, e.line)
+		}
+		i = end
+	}
+	return res.String()
+}
+
+// hunkRange returns the range of a hunk header covering the lines after from up to to
+func hunkRange(from, to int) string {
+	// Empty ranges refer to the line preceding them
+	if from == to {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
+
+// noNewlineMarker follows a last line without a newline in the diff, like in the output of diff
+const noNewlineMarker = `\ No newline at end of file`
+
+// splitLines splits the passed content into its lines.
+// A last line without a newline is followed by the marker, so it differs from the same line with a newline.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		lines[len(lines)-1] += "\n" + noNewlineMarker
+	}
+	return lines
+}
+
+// diffLines returns the edits turning the lines before into the lines after,
+// keeping the longest common subsequence of lines unchanged
+func diffLines(before, after []string) []edit {
+	// common[i][j] is the length of the longest common subsequence of before[i:] and after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			edits = append(edits, edit{' ', before[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, edit{'-', before[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', after[j]})
+			j++
+		}
+	}
+	for ; i < len(before); i++ {
+		edits = append(edits, edit{'-', before[i]})
+	}
+	for ; j < len(after); j++ {
+		edits = append(edits, edit{'+', after[j]})
+	}
+	return edits
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := map[string]struct {
+		before, after, want string
+	}{
+		"equal": {"a\nb\n", "a\nb\n", ""},
+		"insert": {"a\nb\nc\n", "a\nb\nx\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,4 @@\n a\n b\n+x\n c\n"},
+		"delete": {"a\nb\nc\n", "a\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		"change": {"a\nb\nc\n", "a\nB\nc\n",
+			"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		"separate hunks": {"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			"--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n"},
+		"empty before": {"", "a\n",
+			"--- old\n+++ new\n@@ -0,0 +1,1 @@\n+a\n"},
+		"empty after": {"a\n", "",
+			"--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n"},
+		"newline added": {"a\nb", "a\nb\n",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n"},
+		"no newline": {"a\nb", "a\nc",
+			"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+	}
+	for name, test := range tests {
+		if diff := unifiedDiff("old", "new", []byte(test.before), []byte(test.after)); diff != test.want {
+			t.Errorf("%s: unifiedDiff returned\n%s\nwant\n%s", name, diff, test.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Code generated by "middlewarer -output=counter_middleware.go -type=Counter"; DO NOT EDIT.
+package sample
+
+// WrapCounter returns the passed Counter wrapped in the middleware defined in CounterMiddleware
+func WrapCounter(toWrap Counter, wrapper CounterMiddleware) Counter {
+	wrapper.wrapped = toWrap
+	return &wrapper
+}
+
+// CounterMiddleware implements Counter
+type CounterMiddleware struct {
+	wrapped Counter
+
+	AddMiddleware func(AddHandler) AddHandler
+}
+
+var _ Counter = (*CounterMiddleware)(nil)
+
+// NewCounterMiddleware returns a CounterMiddleware wrapping the passed Counter without any middleware
+func NewCounterMiddleware(wrapped Counter) *CounterMiddleware {
+	return &CounterMiddleware{wrapped: wrapped}
+}
+
+// Unwrap returns the wrapped Counter
+func (c *CounterMiddleware) Unwrap() Counter {
+	return c.wrapped
+}
+
+type AddHandler func(n int) int
+
+// Add implements Counter.Add.
+func (c *CounterMiddleware) Add(a0 int) int {
+	if c.AddMiddleware == nil {
+		return c.wrapped.Add(a0)
+	}
+	fun := c.AddMiddleware(c.wrapped.Add)
+	return fun(a0)
+}
+
+// WrappedMethods returns the names of all methods of the wrapped interface in the order of their declaration
+func (c *CounterMiddleware) WrappedMethods() []string {
+	return []string{"Add"}
+}
+
+// CounterFunc is an adapter allowing the use of ordinary functions as Counter.
+// It calls itself when its method Add is called.
+type CounterFunc func(n int) int
+
+// Add calls f.
+func (f CounterFunc) Add(a0 int) int {
+	return f(a0)
+}
@@ -0,0 +1,17 @@
+// Package sample holds middleware generated by middlewarer,
+// so its tests can run the generated code, e.g. with the race detector
+package sample
+
+//go:generate go run ../.. -type=Store -sync -getters -lasterr -toggle -byname -output=store_middleware.go
+//go:generate go run ../.. -type=Counter -output=counter_middleware.go
+
+// Store stores values by key
+type Store interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+}
+
+// Counter counts events
+type Counter interface {
+	Add(n int) int
+}
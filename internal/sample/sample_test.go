@@ -0,0 +1,105 @@
+package sample
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// mapStore is a Store backed by a map, failing to get missing keys
+type mapStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (s *mapStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	if !ok {
+		return "", errors.New("missing " + key)
+	}
+	return value, nil
+}
+
+func (s *mapStore) Put(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+// TestStoreConcurrent calls the methods of the synchronized middleware while changing its middleware
+// and reading the last errors, run it with -race to detect unsynchronized accesses
+func TestStoreConcurrent(t *testing.T) {
+	mw := NewStoreMiddleware(&mapStore{values: make(map[string]string)})
+	// Toggles have to be set before the methods are called
+	mw.PutDisabled = true
+
+	passThrough := func(next GetHandler) GetHandler { return next }
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = mw.Put("key", "value")
+				_, _ = mw.Get("missing")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				mw.SetGetMiddleware(passThrough)
+				if err := mw.SetMiddleware("Get", passThrough); err != nil {
+					t.Error(err)
+				}
+				_ = mw.GetGetMiddleware()
+				mw.SetGetMiddleware(nil)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = mw.LastError("Get")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := mw.Get("key"); err == nil {
+		t.Error("disabled Put stored the value")
+	}
+	if err := mw.LastError("Get"); err == nil || err.Error() != "missing key" {
+		t.Errorf("LastError of Get = %v, want the error of the missing key", err)
+	}
+	if err := mw.LastError("Put"); err != nil {
+		t.Errorf("LastError of Put = %v, want nil", err)
+	}
+}
+
+func TestCounterNoMiddlewareAllocs(t *testing.T) {
+	mw := NewCounterMiddleware(CounterFunc(func(n int) int { return n }))
+	if allocs := testing.AllocsPerRun(100, func() { mw.Add(1) }); allocs != 0 {
+		t.Errorf("calling a method without middleware allocates %v times", allocs)
+	}
+}
+
+// BenchmarkCounter compares calls without middleware, which call the wrapped value directly, to calls through middleware
+func BenchmarkCounter(b *testing.B) {
+	wrapped := CounterFunc(func(n int) int { return n })
+	benchmarks := map[string]*CounterMiddleware{
+		"none": NewCounterMiddleware(wrapped),
+		"middleware": {wrapped: wrapped, AddMiddleware: func(next AddHandler) AddHandler {
+			return func(n int) int { return next(n) }
+		}},
+	}
+	for name, mw := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mw.Add(i)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+// Code generated by "middlewarer -byname -getters -lasterr -output=store_middleware.go -sync -toggle -type=Store"; DO NOT EDIT.
+package sample
+
+import (
+	"fmt"
+	"sync"
+)
+
+// WrapStore returns the passed Store wrapped in the middleware defined in StoreMiddleware
+func WrapStore(toWrap Store, wrapper *StoreMiddleware) Store {
+	wrapper.wrapped = toWrap
+	wrapper.lastErrors = make(map[string]error)
+	return wrapper
+}
+
+// StoreMiddleware implements Store
+//
+// Its middleware can be changed while its methods are called using the setter methods,
+// all other fields have to be set before. It must not be copied after first use.
+type StoreMiddleware struct {
+	wrapped Store
+
+	// mu guards the middleware fields, which are set by the setter methods while the methods are called
+	mu sync.RWMutex
+
+	// lastErrors holds the last error returned per method name, guarded by lastErrorsMu
+	lastErrors   map[string]error
+	lastErrorsMu sync.Mutex
+
+	GetMiddleware func(GetHandler) GetHandler
+	GetDisabled   bool
+	PutMiddleware func(PutHandler) PutHandler
+	PutDisabled   bool
+}
+
+var _ Store = (*StoreMiddleware)(nil)
+
+// NewStoreMiddleware returns a StoreMiddleware wrapping the passed Store without any middleware
+func NewStoreMiddleware(wrapped Store) *StoreMiddleware {
+	return &StoreMiddleware{wrapped: wrapped, lastErrors: make(map[string]error)}
+}
+
+// Unwrap returns the wrapped Store
+func (s *StoreMiddleware) Unwrap() Store {
+	return s.wrapped
+}
+
+type GetHandler func(key string) (string, error)
+type PutHandler func(key string, value string) error
+
+// SetGetMiddleware sets the middleware of Get, it is safe to call concurrently with Get
+func (s *StoreMiddleware) SetGetMiddleware(middleware func(GetHandler) GetHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.GetMiddleware = middleware
+}
+
+// GetGetMiddleware returns the current middleware of Get, which is nil if it has none
+func (s *StoreMiddleware) GetGetMiddleware() func(GetHandler) GetHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.GetMiddleware
+}
+
+// Get implements Store.Get.
+func (s *StoreMiddleware) Get(a0 string) (r0 string, r1 error) {
+	defer func() {
+		if r1 != nil {
+			s.lastErrorsMu.Lock()
+			s.lastErrors["Get"] = r1
+			s.lastErrorsMu.Unlock()
+		}
+	}()
+	if s.GetDisabled {
+		return "", nil
+	}
+	s.mu.RLock()
+	middleware := s.GetMiddleware
+	s.mu.RUnlock()
+	if middleware == nil {
+		return s.wrapped.Get(a0)
+	}
+	fun := middleware(s.wrapped.Get)
+	return fun(a0)
+}
+
+// SetPutMiddleware sets the middleware of Put, it is safe to call concurrently with Put
+func (s *StoreMiddleware) SetPutMiddleware(middleware func(PutHandler) PutHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.PutMiddleware = middleware
+}
+
+// GetPutMiddleware returns the current middleware of Put, which is nil if it has none
+func (s *StoreMiddleware) GetPutMiddleware() func(PutHandler) PutHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.PutMiddleware
+}
+
+// Put implements Store.Put.
+func (s *StoreMiddleware) Put(a0, a1 string) (r0 error) {
+	defer func() {
+		if r0 != nil {
+			s.lastErrorsMu.Lock()
+			s.lastErrors["Put"] = r0
+			s.lastErrorsMu.Unlock()
+		}
+	}()
+	if s.PutDisabled {
+		return nil
+	}
+	s.mu.RLock()
+	middleware := s.PutMiddleware
+	s.mu.RUnlock()
+	if middleware == nil {
+		return s.wrapped.Put(a0, a1)
+	}
+	fun := middleware(s.wrapped.Put)
+	return fun(a0, a1)
+}
+
+// WrappedMethods returns the names of all methods of the wrapped interface in the order of their declaration
+func (s *StoreMiddleware) WrappedMethods() []string {
+	return []string{"Get", "Put"}
+}
+
+// SetMiddleware sets the middleware of the method with the passed name to fn, e.g. to configure middleware by name.
+// It fails if the method has no middleware or fn isn't a middleware of the method.
+func (s *StoreMiddleware) SetMiddleware(method string, fn any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch method {
+	case "Get":
+		middleware, ok := fn.(func(GetHandler) GetHandler)
+		if !ok {
+			return fmt.Errorf("middleware of %s has to be a %T, not a %T", method, middleware, fn)
+		}
+		s.GetMiddleware = middleware
+	case "Put":
+		middleware, ok := fn.(func(PutHandler) PutHandler)
+		if !ok {
+			return fmt.Errorf("middleware of %s has to be a %T, not a %T", method, middleware, fn)
+		}
+		s.PutMiddleware = middleware
+	default:
+		return fmt.Errorf("method %s has no middleware", method)
+	}
+	return nil
+}
+
+// LastError returns the last non-nil error returned by the method with the passed name
+func (s *StoreMiddleware) LastError(method string) error {
+	s.lastErrorsMu.Lock()
+	defer s.lastErrorsMu.Unlock()
+	return s.lastErrors[method]
+}
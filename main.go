@@ -4,20 +4,64 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"go/types"
-	"io"
 	"log"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 
-	"golang.org/x/tools/go/packages"
+	"github.com/DominicWuest/middlewarer/middlewarer"
 )
 
 var (
-	typeName = flag.String("type", "", "The interface type to wrap")
-	output   = flag.String("output", "", "Output file name, default srcdir/<type>_middleware.go")
-	debug    = flag.Bool("d", false, "Enable debug mode, write output to os.Stdout")
+	typeName      = flag.String("type", "", "Comma-separated list of the interface types to wrap, Name=A+B combines A and B into one, import/path#Type refers to another package")
+	output        = flag.String("output", "", "Output file name or directory, default srcdir/<type>_middleware.go")
+	dir           = flag.String("dir", ".", "Directory of the package the code is generated for, which is also the directory of the default output file")
+	buildTags     = flag.String("build-tags", "", "Comma-separated list of the build tags the package is loaded with, e.g. 'linux' to find interfaces in files constrained to it")
+	debug         = flag.Bool("d", false, "Enable debug mode, write output to os.Stdout")
+	names         = flag.Bool("names", false, "Use the parameter names of the interface methods instead of a0, a1, ...")
+	noDoc         = flag.Bool("nodoc", false, "Don't copy the doc comments of the interface methods, so packages passed by -pkg or import/path#Type load faster")
+	pkgPath       = flag.String("pkg", "", "Import path of the package declaring the interfaces, default is the current package")
+	ctx           = flag.Bool("ctx", false, "Pass the context.Context of methods taking one as their first parameter to their middleware")
+	chain         = flag.Bool("chain", false, "Hold a chain of middleware per method instead of a single middleware")
+	pkgName       = flag.String("package", "", "Package name of the generated code, default is the name of the current package")
+	recov         = flag.Bool("recover", false, "Recover from panics in the wrapped methods, reporting them to the OnPanic hook")
+	stdin         = flag.Bool("stdin", false, "Read the Go source declaring the interfaces from os.Stdin instead of loading the current package")
+	timing        = flag.Bool("timing", false, "Generate a WithTiming method setting the middleware of all methods to measure their duration")
+	otel          = flag.Bool("otel", false, "Generate a WithTracing method setting the middleware of all methods to run their calls in an OpenTelemetry span")
+	count         = flag.Bool("count", false, "Count the calls of every method and generate a CallCount method returning them, e.g. for assertions in tests")
+	split         = flag.Bool("split", false, "Split the generated code into <type>_middleware.go and a <type>_<method>_middleware.go per method")
+	formatter     = flag.String("formatter", "goimports", "Command formatting the generated code read from stdin, e.g. 'gofumpt', empty formats it with go/format")
+	noBanner      = flag.Bool("no-banner", false, "Omit the 'Code generated ... DO NOT EDIT.' comment, e.g. to maintain the generated code manually afterwards")
+	inplace       = flag.Bool("inplace", false, "Append the generated code to the -output file, default $GOFILE, instead of writing a separate file")
+	logging       = flag.Bool("log", false, "Generate a WithLogging method setting the middleware of all methods to log their calls using log/slog")
+	metrics       = flag.Bool("metrics", false, "Generate a <Type>Metrics interface and a WithMetrics method setting the middleware of all methods to report their calls to it")
+	licenseHeader = flag.String("license-header", "", "File whose contents are written verbatim at the top of the generated file, e.g. a license comment")
+	trimPrefix    = flag.String("trim-prefix", "", "Prefix trimmed from the type name in the default output file name, e.g. 'HTTP'")
+	force         = flag.Bool("force", false, "Overwrite the output file even if it isn't generated code")
+	lastErr       = flag.Bool("lasterr", false, "Record the last error returned by every method and generate a LastError method returning it")
+	exclude       = flag.String("exclude", "", "Comma-separated list of methods to forward to the wrapped value without any middleware")
+	include       = flag.String("include", "", "Comma-separated list of the only methods with middleware, all others are forwarded to the wrapped value")
+	diff          = flag.Bool("diff", false, "Print a diff of the output file and the generated code instead of writing it, exiting with 1 if they differ")
+	check         = flag.Bool("check", false, "Check whether the output file is up to date instead of writing it, printing its name and exiting with 1 if not")
+	options       = flag.Bool("options", false, "Generate a wrap function taking functional options setting the middleware instead of a middleware struct")
+	tags          = flag.String("tags", "", "Build constraint of the generated file, e.g. '!prod', written as //go:build line")
+	toggle        = flag.Bool("toggle", false, "Generate a <Method>Disabled field per method, making the method return zero values without calling the wrapped value if set")
+	receiver      = flag.String("receiver", "", "Name of the receiver of the generated methods, default is the lowercase first letter of the type")
+	wrappedField  = flag.String("wrapped-field", "", "Name of the field of the middleware struct holding the wrapped value, default is 'wrapped'")
+	ptr           = flag.Bool("ptr", true, "Generate methods with pointer receivers, if false with value receivers wrapping a copy of the middleware struct")
+	handlerFormat = flag.String("handler-format", "", "Template of the handler type names with the fields .Type and .Method or printf-style pattern formatted with the method, default is '%sHandler'")
+	async         = flag.Bool("async", false, "Generate a <Method>Async field per method without results, making the method call the wrapped value in a new goroutine if set")
+	abortable     = flag.Bool("abortable", false, "Generate a <Method>Guard field per method, aborting the call with zero values if it returns false")
+	hooks         = flag.Bool("hooks", false, "Generate a Before<Method> and After<Method> field per method, called with the arguments and the results of every call if set")
+	around        = flag.Bool("around", false, "Generate a <Method>Around field per method, called with the handler proceeding with the call and the arguments if set")
+	builder       = flag.Bool("builder", false, "Generate a <Type>MiddlewareBuilder with a chainable With<Method> method per method and a Build method wrapping a value")
+	jsonOutput    = flag.Bool("json", false, "Write a JSON document describing the generated middleware to os.Stdout instead of the generated code")
+	syncFlag      = flag.Bool("sync", false, "Guard the middleware fields with a mutex and generate setter methods, so the middleware can be changed while the methods are called")
+	getters       = flag.Bool("getters", false, "Generate a Get<Method>Middleware method per method returning its current middleware")
+	byName        = flag.Bool("byname", false, "Generate a SetMiddleware method setting the middleware of a method by its name, e.g. to configure middleware dynamically")
+	structContext = flag.Bool("with-context", false, "Generate a Context field of the middleware struct and a WithContext method returning a copy with another context")
+	verbose       = flag.Bool("v", false, "Enable verbose mode, log the found interfaces, their methods and the output file to os.Stderr")
 )
 
 func main() {
@@ -31,290 +75,311 @@ func main() {
 		os.Exit(1)
 	}
 
-	var destWriter io.Writer
+	typeNames := splitList(*typeName)
 
-	if *debug {
-		destWriter = os.Stdout
-	} else {
-		outFileName := fmt.Sprintf("%s_middleware.go", strings.ToLower(*typeName))
-		if *output != "" {
-			outFileName = *output
-		}
+	if *inplace && (*split || *tags != "") {
+		log.Fatal("-inplace can't be combined with -split or -tags, as the code is appended to a single existing file")
+	}
 
-		out, err := os.OpenFile(outFileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	license := ""
+	if *licenseHeader != "" {
+		content, err := os.ReadFile(*licenseHeader)
 		if err != nil {
-			log.Fatalf("Couldn't open output file %s - %v", outFileName, err)
+			log.Fatalf("Couldn't read license header %s - %v", *licenseHeader, err)
 		}
-		destWriter = out
-		defer func() {
-			if err := out.Close(); err != nil {
-				log.Fatalf("Failed to close output file - %v", err)
-			}
-		}()
+		license = string(content)
 	}
 
-	g := Generator{}
-	g.init(*typeName)
-
-	// Generate the actual code
-	g.generateWrapperCode()
-
-	// Format the code and add imports
-	cmd := exec.Command("goimports")
-
-	// Open stdin and stdout pipes
-	cmdIn := new(bytes.Buffer)
-	cmd.Stdin = cmdIn
-	cmdOut := new(bytes.Buffer)
-	cmd.Stdout = cmdOut
-	cmdStderr := new(bytes.Buffer)
-	cmd.Stderr = cmdStderr
-
-	// Print generated code to formatter
-	g.print(cmdIn)
-
-	// Start command
-	if err := cmd.Start(); err != nil {
-		log.Fatalf("Failed to start format command - %v", err)
+	opts := middlewarer.Options{
+		TypeNames:         typeNames,
+		Dir:               *dir,
+		BuildTags:         splitList(*buildTags),
+		PackagePath:       *pkgPath,
+		PackageName:       *pkgName,
+		BuildConstraint:   *tags,
+		LicenseHeader:     license,
+		Args:              generationArgs(flag.CommandLine),
+		Formatter:         *formatter,
+		NoBanner:          *noBanner,
+		Warnings:          os.Stderr,
+		Exclude:           splitList(*exclude),
+		Include:           splitList(*include),
+		HandlerFormat:     *handlerFormat,
+		Receiver:          *receiver,
+		WrappedField:      *wrappedField,
+		ValueReceivers:    !*ptr,
+		ParamNames:        *names,
+		NoDoc:             *noDoc,
+		Context:           *ctx,
+		Chain:             *chain,
+		Recover:           *recov,
+		Timing:            *timing,
+		Tracing:           *otel,
+		Logging:           *logging,
+		Metrics:           *metrics,
+		FunctionalOptions: *options,
+		Builder:           *builder,
+		Toggle:            *toggle,
+		Abortable:         *abortable,
+		Hooks:             *hooks,
+		Around:            *around,
+		Async:             *async,
+		Sync:              *syncFlag,
+		Getters:           *getters,
+		ByName:            *byName,
+		Count:             *count,
+		LastError:         *lastErr,
+		StructContext:     *structContext,
 	}
-
-	if err := cmd.Wait(); err != nil {
-		stderr, _ := io.ReadAll(cmdStderr)
-		log.Fatalf("Command to format code failed - %v\nStderr: %s\n", err, string(stderr))
+	if *stdin {
+		opts.Source = os.Stdin
 	}
-
-	res, err := io.ReadAll(cmdOut)
-	if err != nil {
-		log.Fatalf("Failed to format generated code - %v\n", err)
+	if *verbose {
+		opts.Verbose = os.Stderr
+	}
+	if *jsonOutput {
+		opts.Metadata = os.Stdout
 	}
 
-	fmt.Fprint(destWriter, string(res))
-}
-
-// The Generator generates the code
-type Generator struct {
-	p          *packages.Package // The package in which this generator was invoked
-	target     *types.Interface  // The target we want to wrap
-	targetName string
-
-	targetFirstLetter string // The first letter of the target name, used as the receiver
-	structName        string // The name of the middleware struct we are generating
+	files := generateFiles(opts, typeNames)
 
-	// Buffers for the different sections of the generated code
-	wrapFunction     *bytes.Buffer
-	middlewareStruct *bytes.Buffer
-	handlerFuncTypes *bytes.Buffer
-	interfaceMethods *bytes.Buffer
-}
-
-// init inits the generator.
-// It loads the package to parse and looks for the interface
-// with name matching the passed target string.
-func (g *Generator) init(target string) {
-	g.targetName = target
-
-	// Load the package of the current directory
-	packs, err := packages.Load(&packages.Config{
-		// TODO: Make sure to minimize information here, probably getting too much
-		Mode: packages.NeedName | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedSyntax | packages.NeedImports,
-	}, ".")
-	if err != nil {
-		log.Printf("Failed to load packages - %v", err)
-		os.Exit(1)
+	if *jsonOutput {
+		return
 	}
 
-	if len(packs) != 1 {
-		log.Printf("Loaded package length is not 1, but %d", len(packs))
-		os.Exit(1)
+	if *debug {
+		for _, file := range files {
+			fmt.Fprint(os.Stdout, string(file.Content))
+		}
+		return
 	}
-	g.p = packs[0]
 
-	// Look for the matching interface
-	obj := g.p.Types.Scope().Lookup(target)
-	if obj == nil {
-		log.Fatalf("Couldn't find target object '%s' in source file", target)
-	}
+	if *diff || *check {
+		stale := false
+		for _, file := range files {
+			// A missing output file is compared as empty
+			existing, err := os.ReadFile(file.Name)
+			if err != nil && !os.IsNotExist(err) {
+				log.Fatalf("Couldn't read output file %s - %v", file.Name, err)
+			}
+			if bytes.Equal(existing, file.Content) {
+				continue
+			}
 
-	iFace, ok := obj.Type().Underlying().(*types.Interface)
-	if !ok {
-		log.Fatalf("Provided target object '%s' is not an interface", target)
+			stale = true
+			if *diff {
+				fmt.Fprint(os.Stdout, unifiedDiff(file.Name, file.Name+" (generated)", existing, file.Content))
+			} else {
+				fmt.Fprintln(os.Stdout, file.Name)
+			}
+		}
+		if stale {
+			os.Exit(1)
+		}
+		return
 	}
 
-	if iFace.Empty() {
-		log.Fatalf("Trying to generate middlewarer for an empty interface")
+	for _, file := range files {
+		if err := checkOverwrite(file.Name); err != nil {
+			log.Fatal(err)
+		}
+		if err := writeFileAtomic(file.Name, file.Content); err != nil {
+			log.Fatalf("Couldn't write output file %s - %v", file.Name, err)
+		}
+		if *verbose {
+			log.Printf("wrote %s", file.Name)
+		}
 	}
-
-	g.target = iFace
-}
-
-// Format string of the function returning a wrapped instance of the passed interface
-// The arguments for the format string are:
-//
-//	[1]: The interface type name we are wrapping
-//	[2]: The name of the middleware struct
-const wrapFunctionFormat = `// Wrap%[1]s returns the passed %[1]s wrapped in the middleware defined in %[2]s
-func Wrap%[1]s(toWrap %[1]s, wrapper %[2]s) %[1]s {
-	wrapper.wrapped = toWrap
-	return &wrapper
 }
-`
 
-// generateWrapperCode generates the code for the wrapper of the target interface
-func (g *Generator) generateWrapperCode() {
-	g.wrapFunction = new(bytes.Buffer)
-	g.middlewareStruct = new(bytes.Buffer)
-	g.handlerFuncTypes = new(bytes.Buffer)
-	g.interfaceMethods = new(bytes.Buffer)
+// generateFiles generates the middleware configured in opts,
+// returning the files to write with their names resolved against the output flags
+func generateFiles(opts middlewarer.Options, typeNames []string) []middlewarer.File {
+	if *split {
+		files, err := middlewarer.GenerateFiles(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	g.structName = fmt.Sprintf("%sMiddleware", g.targetName)
-	g.targetFirstLetter = strings.ToLower(g.targetName[0:1])
+		// The split files are placed in the output directory, which defaults to the package directory
+		outDir := *dir
+		if *output != "" {
+			if info, err := os.Stat(*output); err != nil || !info.IsDir() {
+				log.Fatalf("output %s has to be an existing directory to split the generated code into multiple files", *output)
+			}
+			outDir = *output
+		}
+		for i := range files {
+			files[i].Name = filepath.Join(outDir, trimTypePrefix(files[i].Name))
+		}
+		return files
+	}
 
-	// Write wrap function
-	fmt.Fprintf(g.wrapFunction, wrapFunctionFormat, g.targetName, g.structName)
+	res := new(bytes.Buffer)
+	if err := middlewarer.Generate(res, opts); err != nil {
+		log.Fatal(err)
+	}
 
-	// Write header of middleware struct
-	fmt.Fprintf(g.middlewareStruct, "// %s implements %s\n", g.structName, g.targetName)
-	fmt.Fprintf(g.middlewareStruct, "type %s struct {\n", g.structName)
-	fmt.Fprintf(g.middlewareStruct, "\twrapped %s\n", g.targetName)
-	fmt.Fprintln(g.middlewareStruct)
+	if *inplace {
+		// go generate sets $GOFILE to the file containing the directive
+		target := *output
+		if target == "" {
+			if os.Getenv("GOFILE") == "" {
+				log.Fatal("-inplace requires -output naming the file to append to if not run by go generate")
+			}
+			target = filepath.Join(*dir, os.Getenv("GOFILE"))
+		}
+		src, err := os.ReadFile(target)
+		if err != nil {
+			log.Fatalf("Couldn't read file %s to append to - %v", target, err)
+		}
+		inlined, err := middlewarer.Inline(src, res.Bytes(), opts.Formatter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return []middlewarer.File{{Name: target, Content: inlined}}
+	}
 
-	g.generateInterfaceMethods(g.target)
+	outFileName := filepath.Join(*dir, trimTypePrefix(strings.ToLower(compositeName(typeNames[0]))+middlewarer.MiddlewareFileSuffix(*pkgName)))
+	if *output != "" {
+		// Place the default file name inside of existing directories
+		if info, err := os.Stat(*output); err == nil && info.IsDir() {
+			outFileName = filepath.Join(*output, filepath.Base(outFileName))
+		} else {
+			outFileName = *output
+		}
+	}
+	return []middlewarer.File{{Name: outFileName, Content: res.Bytes()}}
+}
 
-	// Write footer of middleware struct
-	fmt.Fprint(g.middlewareStruct, "}\n")
+// outputModeFlags are the flags only affecting how and from where the generated code is output, not the code itself
+var outputModeFlags = map[string]bool{"d": true, "diff": true, "check": true, "json": true, "v": true, "dir": true, "force": true}
+
+// generationArgs returns the arguments of the invocation recorded in the header of the generated code,
+// which are the flags set in the passed flag set in lexical order and its remaining arguments.
+// The flags only affecting the output mode are left out, so the recorded invocation doesn't depend on them
+// nor on how the flags are written, e.g. -dir=sub or -dir sub.
+func generationArgs(flags *flag.FlagSet) []string {
+	var args []string
+	flags.Visit(func(f *flag.Flag) {
+		if outputModeFlags[f.Name] {
+			return
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() && f.Value.String() == "true" {
+			args = append(args, "-"+f.Name)
+			return
+		}
+		args = append(args, "-"+f.Name+"="+f.Value.String())
+	})
+	return append(args, flags.Args()...)
 }
 
-// interfaceMethodFormatReturn is the format string for interface methods
-// which have a return value
-// The arguments for the format string are:
-//
-//	[1]: The first letter of the receiver type
-//	[2]: The receiver type
-//	[3]: The function name
-//	[4]: The function parameters
-//	[5]: The function return type
-//	[6]: The function arguments list
-const interfaceMethodFormatReturn = `func (%[1]s *%[2]s) %[3]s(%[4]s) %[5]s {
-	fun := %[1]s.wrapped.%[3]s
-	if %[1]s.%[3]sMiddleware != nil {
-		fun = %[1]s.%[3]sMiddleware(fun)
+// compositeName returns the name of the passed type, which may be a composite of interfaces
+// separated by + and optionally named by a preceding Name=, or qualified by a preceding import/path#
+func compositeName(typeName string) string {
+	if name, _, named := strings.Cut(typeName, "="); named {
+		return name
 	}
-	return fun(%[6]s)
+	parts := strings.Split(typeName, "+")
+	for i, part := range parts {
+		if _, name, qualified := strings.Cut(part, "#"); qualified {
+			parts[i] = name
+		}
+	}
+	return strings.Join(parts, "")
 }
 
-`
-
-// interfaceMethodFormatReturn is the format string for interface methods
-// which have no return value
-// The arguments for the format string are:
-//
-//	[1]: The first letter of the receiver type
-//	[2]: The receiver type
-//	[3]: The function name
-//	[4]: The function parameters
-//	[5]: The function arguments list
-const interfaceMethodFormatVoid = `func (%[1]s *%[2]s) %[3]s(%[4]s) {
-	fun := %[1]s.wrapped.%[3]s
-	if %[1]s.%[3]sMiddleware != nil {
-		fun = %[1]s.%[3]sMiddleware(fun)
+// trimTypePrefix removes the prefix passed by -trim-prefix from the type name
+// the passed file name starts with, unless nothing of the type name would remain
+func trimTypePrefix(fileName string) string {
+	trimmed := strings.TrimPrefix(fileName, strings.ToLower(*trimPrefix))
+	if strings.HasPrefix(trimmed, "_") {
+		return fileName
 	}
-	fun(%[5]s)
+	return trimmed
 }
 
-`
-
-// generateInterfaceMethods generates the function declarations of
-// the methods required by the wrapper to implement
-func (g *Generator) generateInterfaceMethods(target *types.Interface) {
-	for i := 0; i < target.NumMethods(); i++ {
-		fun := target.Method(i)
-
-		// Generate the handler type
-		handlerTypeName := fmt.Sprintf("%sHandler", fun.Name())
-		sigBuf := new(bytes.Buffer)
-		types.WriteSignature(sigBuf, fun.Type().(*types.Signature), g.typeStringQuantifier)
-		sigString, _ := io.ReadAll(sigBuf)
-		fmt.Fprintf(g.handlerFuncTypes, "type %s func%s\n", handlerTypeName, string(sigString))
-
-		// Generate the struct field
-		structFieldName := fmt.Sprintf("%sMiddleware", fun.Name())
-		fmt.Fprintf(g.middlewareStruct, "\t%s func(%[2]s) %[2]s\n", structFieldName, handlerTypeName)
-
-		// Generate the middleware method
-		g.generateMiddlewareMethod(fun)
+// splitList splits the passed comma-separated list, ignoring surrounding whitespace and empty elements
+func splitList(list string) []string {
+	var elems []string
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
 	}
+	return elems
 }
 
-// generateMiddlewareMethod generates the code needed by the method implementation of the function
-func (g *Generator) generateMiddlewareMethod(fun *types.Func) {
-	methodSignature := fun.Type().(*types.Signature)
-
-	parametersList := strings.Builder{}
-	argumentsList := strings.Builder{}
+// generatedCodeRegexp matches the comment marking a file as generated, following the Go convention
+var generatedCodeRegexp = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
 
-	for i := 0; i < methodSignature.Params().Len(); i++ {
-		param := methodSignature.Params().At(i)
-		typeString := types.TypeString(param.Type(), g.typeStringQuantifier)
-		fmt.Fprintf(&argumentsList, "a%d, ", i)
-		fmt.Fprintf(&parametersList, "a%d %s, ", i, typeString)
+// isGeneratedFile returns whether the named file doesn't exist yet or is marked as generated
+// by a comment before its package clause
+func isGeneratedFile(name string) (bool, error) {
+	content, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	// Remove trailing commas
-	parameters := strings.TrimSuffix(parametersList.String(), ", ")
-	arguments := strings.TrimSuffix(argumentsList.String(), ", ")
-
-	if methodSignature.Results().Len() == 0 {
-		fmt.Fprintf(g.interfaceMethods, interfaceMethodFormatVoid,
-			g.targetFirstLetter,
-			g.structName,
-			fun.Name(),
-			parameters,
-			arguments,
-		)
-	} else {
-		returnTypes := make([]string, methodSignature.Results().Len())
-		for i := 0; i < methodSignature.Results().Len(); i++ {
-			returnTypes[i] = types.TypeString(methodSignature.Results().At(i).Type(), g.typeStringQuantifier)
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if generatedCodeRegexp.MatchString(line) {
+			return true, nil
 		}
-		returnType := strings.Join(returnTypes, ", ")
-
-		if methodSignature.Results().Len() != 1 {
-			returnType = "(" + returnType + ")"
+		if strings.HasPrefix(line, "package ") {
+			break
 		}
-
-		fmt.Fprintf(g.interfaceMethods, interfaceMethodFormatReturn,
-			g.targetFirstLetter,
-			g.structName,
-			fun.Name(),
-			parameters,
-			returnType,
-			arguments,
-		)
 	}
+	return false, nil
 }
 
-// print writes the generated code to the provided io.Writer
-func (g *Generator) print(w io.Writer) {
-	// Print header
-	fmt.Fprintf(w, "// Code generated by \"middlewarer %s\"; DO NOT EDIT.\n", strings.Join(os.Args[1:], " "))
-	fmt.Fprintf(w, "package %s\n", g.p.Name)
-	fmt.Fprintln(w)
-
-	// Print the generated code
-	w.Write(g.wrapFunction.Bytes())
-	fmt.Fprintln(w)
-	w.Write(g.middlewareStruct.Bytes())
-	fmt.Fprintln(w)
-	w.Write(g.handlerFuncTypes.Bytes())
-	fmt.Fprintln(w)
-	w.Write(g.interfaceMethods.Bytes())
-	fmt.Fprintln(w)
+// checkOverwrite returns an error if the named file mustn't be overwritten with generated code.
+// Hand-written files are only overwritten if forced, appending to them in place is intended.
+func checkOverwrite(name string) error {
+	if *force || *inplace {
+		return nil
+	}
+	generated, err := isGeneratedFile(name)
+	if err != nil {
+		return fmt.Errorf("Couldn't read output file %s - %w", name, err)
+	}
+	if !generated {
+		return fmt.Errorf("refusing to overwrite %s, which isn't generated code, use -force to overwrite it anyway", name)
+	}
+	return nil
 }
 
-// typeStringQuantifier is to be used as the quantifier for calls to [types.TypeString]
-func (g Generator) typeStringQuantifier(p *types.Package) string {
-	if p.Path() == g.p.PkgPath {
-		return ""
+// renameFunc replaces files with the written temporary files, tests replace it to simulate failures
+var renameFunc = os.Rename
+
+// writeFileAtomic writes the passed data to the named file.
+// The data is written to a temporary file in the same directory first,
+// which then replaces the named file, so it is never left partially written.
+func writeFileAtomic(name string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Clean up the temporary file if it didn't replace the named file
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	return p.Name()
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return renameFunc(tmp.Name(), name)
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerationArgs(t *testing.T) {
+	tests := [][]string{
+		{"-type=Foo", "-dir", "sub", "-timing", "-d"},
+		{"-dir=sub", "-timing", "-type", "Foo"},
+		{"-check", "-type=Foo", "-timing=true", "-force", "-dir=sub"},
+	}
+	want := []string{"-timing", "-type=Foo"}
+
+	for _, args := range tests {
+		flags := flag.NewFlagSet("middlewarer", flag.ContinueOnError)
+		flags.String("type", "", "")
+		flags.String("dir", ".", "")
+		flags.Bool("timing", false, "")
+		flags.Bool("d", false, "")
+		flags.Bool("check", false, "")
+		flags.Bool("force", false, "")
+		if err := flags.Parse(args); err != nil {
+			t.Fatalf("failed to parse %v - %v", args, err)
+		}
+
+		if got := generationArgs(flags); !reflect.DeepEqual(got, want) {
+			t.Errorf("generationArgs of %v = %v, want %v", args, got, want)
+		}
+	}
+}
+
+func TestCompositeName(t *testing.T) {
+	tests := map[string]string{
+		"Foo":                    "Foo",
+		"io#Reader":              "Reader",
+		"A+B":                    "AB",
+		"RW=A+B":                 "RW",
+		"io#Reader+io#Writer":    "ReaderWriter",
+		"RW=io#Reader+io#Writer": "RW",
+	}
+	for typeName, want := range tests {
+		if name := compositeName(typeName); name != want {
+			t.Errorf("compositeName(%q) = %q, want %q", typeName, name, want)
+		}
+	}
+}
+
+func TestIsGeneratedFile(t *testing.T) {
+	tests := map[string]struct {
+		content   string
+		generated bool
+	}{
+		"generated":              {"// Code generated by \"middlewarer -type=Foo\"; DO NOT EDIT.\npackage p\n", true},
+		"build constraint":       {"//go:build !prod\n\n// Code generated by \"middlewarer -type=Foo\"; DO NOT EDIT.\npackage p\n", true},
+		"crlf":                   {"// Code generated by \"middlewarer -type=Foo\"; DO NOT EDIT.\r\npackage p\r\n", true},
+		"hand-written":           {"// Package p does things.\npackage p\n", false},
+		"banner after package":   {"package p\n\n// Code generated by \"middlewarer -type=Foo\"; DO NOT EDIT.\n", false},
+		"banner in other format": {"// Code generated by middlewarer, do not edit.\npackage p\n", false},
+	}
+	dir := t.TempDir()
+	for name, test := range tests {
+		path := filepath.Join(dir, name+".go")
+		if err := os.WriteFile(path, []byte(test.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if generated, err := isGeneratedFile(path); err != nil || generated != test.generated {
+			t.Errorf("%s: isGeneratedFile = %t, %v, want %t", name, generated, err, test.generated)
+		}
+	}
+
+	// Files which don't exist yet can be written
+	if generated, err := isGeneratedFile(filepath.Join(dir, "missing.go")); err != nil || !generated {
+		t.Errorf("missing file: isGeneratedFile = %t, %v, want true", generated, err)
+	}
+}
+
+func TestCheckOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foo_middleware.go")
+	if err := os.WriteFile(path, []byte("package p\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkOverwrite(path); err == nil || !strings.Contains(err.Error(), "refusing to overwrite") {
+		t.Errorf("checkOverwrite of a hand-written file returned %v, want a refusal", err)
+	}
+
+	defer func(forced bool) { *force = forced }(*force)
+	*force = true
+	if err := checkOverwrite(path); err != nil {
+		t.Errorf("checkOverwrite of a hand-written file with -force returned %v", err)
+	}
+}
+
+func TestTrimTypePrefix(t *testing.T) {
+	tests := []struct {
+		prefix, fileName, want string
+	}{
+		{"", "httpclient_middleware.go", "httpclient_middleware.go"},
+		{"HTTP", "httpclient_middleware.go", "client_middleware.go"},
+		{"http", "httpclient_middleware.go", "client_middleware.go"},
+		{"HTTP", "grpcclient_middleware.go", "grpcclient_middleware.go"},
+		// Nothing of the type name would remain
+		{"HTTP", "http_middleware.go", "http_middleware.go"},
+	}
+
+	defer func(prefix string) { *trimPrefix = prefix }(*trimPrefix)
+	for _, test := range tests {
+		*trimPrefix = test.prefix
+		if got := trimTypePrefix(test.fileName); got != test.want {
+			t.Errorf("trimTypePrefix(%q) with prefix %q = %q, want %q", test.fileName, test.prefix, got, test.want)
+		}
+	}
+}
+
+// assertNoTempFiles fails the test if the passed directory contains another file than the passed one
+func assertNoTempFiles(t *testing.T, dir, file string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != file {
+			t.Errorf("writeFileAtomic left the file %s", entry.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo_middleware.go")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatalf("writeFileAtomic failed - %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "new" {
+		t.Errorf("the file contains %q after writing it, want %q", content, "new")
+	}
+	assertNoTempFiles(t, dir, "foo_middleware.go")
+
+	// A failed write leaves the existing file intact
+	defer func(rename func(string, string) error) { renameFunc = rename }(renameFunc)
+	renameFunc = func(string, string) error { return errors.New("rename failed") }
+	if err := writeFileAtomic(path, []byte("newer")); err == nil {
+		t.Error("writeFileAtomic succeeded although the rename failed")
+	}
+	if content, _ := os.ReadFile(path); string(content) != "new" {
+		t.Errorf("the file contains %q after a failed write, want the previous content %q", content, "new")
+	}
+	assertNoTempFiles(t, dir, "foo_middleware.go")
+}
+
+func TestWriteFileAtomicDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "out"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "out", "keep.go"), []byte("package p\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "out"), []byte("new")); err == nil {
+		t.Error("writeFileAtomic replaced a directory")
+	}
+	assertNoTempFiles(t, dir, "out")
+}
@@ -0,0 +1,10 @@
+//go:build go1.22
+
+package middlewarer
+
+import "go/types"
+
+// unalias returns the type denoted by the passed type, resolving any aliases
+func unalias(t types.Type) types.Type {
+	return types.Unalias(t)
+}
@@ -0,0 +1,10 @@
+//go:build !go1.22
+
+package middlewarer
+
+import "go/types"
+
+// unalias returns the passed type, as aliases are always resolved before Go 1.22
+func unalias(t types.Type) types.Type {
+	return t
+}
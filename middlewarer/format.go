@@ -0,0 +1,68 @@
+package middlewarer
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// defaultFormatter is the formatter command which is optional,
+// so the code is formatted with go/format if it is not installed
+const defaultFormatter = "goimports"
+
+// formatCode formats the passed code by piping it through the passed formatter command.
+// If the formatter is empty or the default formatter is not installed, the code is formatted with go/format,
+// in which case the imports printed by the generator have to suffice.
+func formatCode(src []byte, formatter string) ([]byte, error) {
+	args := strings.Fields(formatter)
+	if len(args) == 0 {
+		return formatSource(src)
+	}
+	if _, err := exec.LookPath(args[0]); err != nil && formatter == defaultFormatter {
+		return formatSource(src)
+	}
+
+	return runFormatter(bytes.NewReader(src), args)
+}
+
+// formatSource formats the passed code in-process using go/format
+func formatSource(src []byte) ([]byte, error) {
+	res, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code - %w", err)
+	}
+	return res, nil
+}
+
+// runFormatter formats the passed code using the passed formatter command and its arguments,
+// which reads the code from stdin and writes the formatted code to stdout
+func runFormatter(src io.Reader, args []string) ([]byte, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+
+	// Open stdin and stdout pipes
+	cmd.Stdin = src
+	cmdOut := new(bytes.Buffer)
+	cmd.Stdout = cmdOut
+	cmdStderr := new(bytes.Buffer)
+	cmd.Stderr = cmdStderr
+
+	// Start command
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start format command - %w", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		stderr, _ := io.ReadAll(cmdStderr)
+		return nil, fmt.Errorf("command to format code failed - %w\nStderr: %s", err, string(stderr))
+	}
+
+	res, err := io.ReadAll(cmdOut)
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code - %w", err)
+	}
+
+	return res, nil
+}
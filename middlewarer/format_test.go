@@ -0,0 +1,38 @@
+package middlewarer
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGenerateFormatters(t *testing.T) {
+	src := `package p
+
+type Pinger interface {
+	Ping() error
+}
+`
+	// The default formatter falls back to go/format if goimports isn't installed
+	formatters := []string{"", defaultFormatter}
+	if _, err := exec.LookPath("gofmt"); err == nil {
+		formatters = append(formatters, "gofmt")
+	}
+
+	for _, formatter := range formatters {
+		generated := generateSource(t, src, Options{TypeNames: []string{"Pinger"}, Formatter: formatter})
+		typeCheck(t, src, generated)
+		if !strings.Contains(generated, "\npackage p\n") {
+			t.Errorf("code formatted by %q has no package clause\n%s", formatter, generated)
+		}
+	}
+}
+
+func TestFormatCodeMinimal(t *testing.T) {
+	for _, formatter := range []string{"", defaultFormatter} {
+		formatted, err := formatCode([]byte("package p\n"), formatter)
+		if err != nil || string(formatted) != "package p\n" {
+			t.Errorf("formatting a package clause with %q = %q, %v", formatter, formatted, err)
+		}
+	}
+}
@@ -0,0 +1,1260 @@
+package middlewarer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// The generator generates the code for a single target interface
+type generator struct {
+	opts *Options // The options of the generation
+
+	p           *packages.Package // The package in which this generator was invoked
+	imports     map[string]string // The packages referenced by the generated code, mapping path to name
+	packageName string            // The name of the package of the generated code
+	declared    map[string]string // The package level identifiers declared by the generated code, mapping to their origin
+	members     map[string]string // The fields and methods of the middleware struct, mapping to their origin
+	target      *types.Interface  // The target we want to wrap
+	targetName  string
+	targetType  string // The target type as referenced in the generated code, qualified if declared in another package
+
+	targetPackage *packages.Package // The package declaring the target, may differ from p
+	declaration   types.Type        // The type of the target with aliases resolved, nil if the target is a composite
+
+	parts         []types.Type // The interfaces combined by a composite target, empty if the target is a single interface
+	compositeDecl string       // The declaration of the named composite target, empty if there is none
+
+	handlerFormat *template.Template // The template of the handler type names
+
+	typeParams string // The type parameter list of the target, e.g. "[K comparable, V any]", empty if not generic
+	typeArgs   string // The type parameters of the target used as arguments, e.g. "[K, V]", empty if not generic
+
+	receiver   string // The name of the receiver of the generated methods
+	structName string // The name of the middleware struct we are generating
+
+	wrappedField string // The name of the field of the middleware struct holding the wrapped value
+
+	wrapFuncName string // The name of the function wrapping a value in the middleware
+	newFuncName  string // The name of the constructor of the middleware struct
+
+	optionTypeName string // The name of the functional option type, if functional options are generated
+
+	unexported bool // Whether the target has unexported methods, making the generated code package-private
+
+	methods []*method // The methods of the target
+	err     error     // The first error encountered while generating code
+
+	// Buffers for the different sections of the generated code
+	wrapFunction     *bytes.Buffer
+	middlewareStruct *bytes.Buffer
+	handlerFuncTypes *bytes.Buffer
+	helperMethods    *bytes.Buffer
+}
+
+// defaultHandlerFormat is the printf-style pattern of the handler type names if none is configured
+const defaultHandlerFormat = "%sHandler"
+
+// init inits the generator.
+// It looks for the interface with name matching the passed target string
+// in the already loaded package of the generator.
+func (g *generator) init(target string) error {
+	g.targetName = target
+
+	format := defaultHandlerFormat
+	if g.opts.HandlerFormat != "" {
+		format = g.opts.HandlerFormat
+	}
+	if !strings.Contains(format, "{{") {
+		// Printf-style patterns are formatted with the method name
+		format = fmt.Sprintf("{{printf %s .Method}}", strconv.Quote(format))
+	}
+	var err error
+	if g.handlerFormat, err = template.New("handler").Parse(format); err != nil {
+		return fmt.Errorf("invalid handler format - %w", err)
+	}
+
+	if strings.Contains(target, "+") {
+		if err := g.initComposite(target); err != nil {
+			return err
+		}
+	} else {
+		obj, typ, iFace, err := g.lookupInterface(target)
+		if err != nil {
+			return err
+		}
+		g.target = iFace
+		g.declaration = typ
+
+		g.targetType = g.targetName
+		if qualifier := g.typeStringQuantifier(obj.Pkg()); qualifier != "" {
+			g.targetType = qualifier + "." + g.targetName
+		}
+
+		// Collect the type parameters of generic interfaces, aliases of instantiated interfaces are not generic
+		if named, ok := typ.(*types.Named); ok && named.TypeParams().Len() > 0 && named.TypeArgs().Len() == 0 {
+			typeParams := make([]string, named.TypeParams().Len())
+			typeArgs := make([]string, named.TypeParams().Len())
+			for i := 0; i < named.TypeParams().Len(); i++ {
+				param := named.TypeParams().At(i)
+				typeParams[i] = fmt.Sprintf("%s %s", param.Obj().Name(), types.TypeString(param.Constraint(), g.typeStringQuantifier))
+				typeArgs[i] = param.Obj().Name()
+			}
+			g.typeParams = "[" + strings.Join(typeParams, ", ") + "]"
+			g.typeArgs = "[" + strings.Join(typeArgs, ", ") + "]"
+		}
+	}
+	g.opts.logf("found interface %s in %s with %d methods", g.targetType, g.targetPackage.PkgPath, g.target.NumMethods())
+
+	iFace := g.target
+
+	for i := 0; i < iFace.NumMethods(); i++ {
+		if iFace.Method(i).Exported() {
+			continue
+		}
+		// Unexported methods can only be implemented inside of the declaring package
+		if pkg := iFace.Method(i).Pkg(); !g.isLocal(pkg) {
+			return fmt.Errorf("%sinterface '%s' has the unexported method %s, so it can't be implemented outside of package %s", g.position(iFace.Method(i)), target, iFace.Method(i).Name(), pkg.Path())
+		}
+		g.unexported = true
+	}
+
+	return nil
+}
+
+// position returns the source position of the declaration of the passed object followed by a colon,
+// so it can prefix error messages, or an empty string if the position is unknown
+func (g *generator) position(obj types.Object) string {
+	pos := g.targetPackage.Fset.Position(obj.Pos())
+	if !pos.IsValid() {
+		return ""
+	}
+	return pos.String() + ": "
+}
+
+// lookupInterface looks for the interface with the passed name in the package declaring the target.
+// It returns the declaring object, its type with aliases resolved, and the interface.
+func (g *generator) lookupInterface(name string) (types.Object, types.Type, *types.Interface, error) {
+	// Anonymous interfaces, e.g. of struct fields, have no name the generated code could refer to
+	if typeName, field, nested := strings.Cut(name, "."); nested {
+		return nil, nil, nil, fmt.Errorf("%w: '%s' selects %s of %s, only interfaces declared as named types are supported, declare the interface of %s as a named type to wrap it", ErrTypeNotFound, name, field, typeName, field)
+	}
+
+	obj := g.targetPackage.Types.Scope().Lookup(name)
+	if obj == nil {
+		return nil, nil, nil, fmt.Errorf("%w: couldn't find '%s' in package %s", ErrTypeNotFound, name, g.targetPackage.PkgPath)
+	}
+
+	// Aliases are resolved to the type they denote, while the generated code still refers to the alias
+	typ := unalias(obj.Type())
+
+	iFace, ok := typ.Underlying().(*types.Interface)
+	if !ok {
+		if s, isStruct := typ.Underlying().(*types.Struct); isStruct {
+			if implemented := g.implementedInterfaces(typ); len(implemented) > 0 {
+				return nil, nil, nil, fmt.Errorf("%s%w: '%s' is a struct, pass one of the interfaces it implements instead: %s", g.position(obj), ErrNotInterface, name, strings.Join(implemented, ", "))
+			}
+			if fields := anonymousInterfaceFields(s); len(fields) > 0 {
+				return nil, nil, nil, fmt.Errorf("%s%w: '%s' is a struct, the anonymous interfaces of its fields %s can't be wrapped, declare them as named types instead", g.position(obj), ErrNotInterface, name, strings.Join(fields, ", "))
+			}
+		}
+		return nil, nil, nil, fmt.Errorf("%s%w: '%s'", g.position(obj), ErrNotInterface, name)
+	}
+
+	// Interfaces with type sets can only be used as constraints, so no value can be wrapped
+	if !iFace.IsMethodSet() {
+		return nil, nil, nil, fmt.Errorf("%s%w: '%s' restricts its type set, only interfaces consisting of methods are supported", g.position(obj), ErrConstraintInterface, name)
+	}
+
+	if iFace.Empty() {
+		if iFace.NumEmbeddeds() == 0 {
+			return nil, nil, nil, fmt.Errorf("%s%w: '%s' declares no methods", g.position(obj), ErrEmptyInterface, name)
+		}
+		embeddeds := make([]string, iFace.NumEmbeddeds())
+		for i := range embeddeds {
+			embeddeds[i] = types.TypeString(iFace.EmbeddedType(i), g.typeStringQuantifier)
+		}
+		return nil, nil, nil, fmt.Errorf("%s%w: '%s' only embeds the empty interfaces %s", g.position(obj), ErrEmptyInterface, name, strings.Join(embeddeds, ", "))
+	}
+
+	return obj, typ, iFace, nil
+}
+
+// initComposite inits the generator for a composite of the interfaces separated by + in the passed target.
+// The composite is named by a preceding Name=, else by the concatenation of the names of its interfaces.
+// Without a name, the composite is referenced as an anonymous interface embedding all of them.
+func (g *generator) initComposite(target string) error {
+	name, list, named := strings.Cut(target, "=")
+	if !named {
+		list = target
+	}
+	partNames := strings.Split(list, "+")
+	if !named {
+		name = strings.Join(partNames, "")
+	}
+	if !token.IsIdentifier(name) {
+		return fmt.Errorf("composite name '%s' is not a valid identifier", name)
+	}
+	g.targetName = name
+
+	methods := make(map[string]*types.Func)
+	origins := make(map[string]string)
+	embeddeds := make([]string, len(partNames))
+	for i, partName := range partNames {
+		partName = strings.TrimSpace(partName)
+		_, typ, iFace, err := g.lookupInterface(partName)
+		if err != nil {
+			return err
+		}
+		if named, ok := typ.(*types.Named); ok && named.TypeParams().Len() > 0 && named.TypeArgs().Len() == 0 {
+			return fmt.Errorf("generic interface '%s' can't be part of a composite", partName)
+		}
+
+		// Methods declared by multiple interfaces are only implemented once, if their signatures are identical
+		for j := 0; j < iFace.NumMethods(); j++ {
+			method := iFace.Method(j)
+			if other, ok := methods[method.Id()]; ok && !types.Identical(other.Type(), method.Type()) {
+				return fmt.Errorf("method %s of %s conflicts with the one of %s", method.Name(), partName, origins[method.Id()])
+			}
+			methods[method.Id()] = method
+			origins[method.Id()] = partName
+		}
+
+		g.parts = append(g.parts, typ)
+		embeddeds[i] = types.TypeString(typ, g.typeStringQuantifier)
+	}
+
+	g.target = types.NewInterfaceType(nil, g.parts).Complete()
+
+	g.targetType = "interface{ " + strings.Join(embeddeds, "; ") + " }"
+	if named {
+		// The named composite is declared by the generated code
+		g.compositeDecl = fmt.Sprintf("// %[1]s combines %[2]s\ntype %[1]s %[3]s\n\n", name, strings.Join(embeddeds, ", "), g.targetType)
+		g.targetType = name
+	}
+	return nil
+}
+
+// anonymousInterfaceFields returns the names of the fields of the passed struct whose type is a non-empty interface literal
+func anonymousInterfaceFields(s *types.Struct) []string {
+	var fields []string
+	for i := 0; i < s.NumFields(); i++ {
+		if iFace, ok := unalias(s.Field(i).Type()).(*types.Interface); ok && !iFace.Empty() {
+			fields = append(fields, s.Field(i).Name())
+		}
+	}
+	return fields
+}
+
+// implementedInterfaces returns the names of the interfaces declared in the package of the target,
+// which are implemented by the passed type or a pointer to it
+func (g *generator) implementedInterfaces(typ types.Type) []string {
+	var implemented []string
+	scope := g.targetPackage.Types.Scope()
+	// The names of the scope are sorted, so the result is deterministic
+	for _, name := range scope.Names() {
+		obj, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || (!obj.Exported() && g.targetPackage != g.p) {
+			continue
+		}
+		// Generic interfaces have to be instantiated to be implemented
+		if named, ok := obj.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+			continue
+		}
+		iFace, ok := obj.Type().Underlying().(*types.Interface)
+		if !ok || iFace.Empty() || !iFace.IsMethodSet() {
+			continue
+		}
+		if types.Implements(typ, iFace) || types.Implements(types.NewPointer(typ), iFace) {
+			implemented = append(implemented, name)
+		}
+	}
+	return implemented
+}
+
+// Format string of the function returning a wrapped instance of the passed interface
+// The arguments for the format string are:
+//
+//	[1]: The interface type name we are wrapping
+//	[2]: The name of the middleware struct
+//	[3]: The type parameter list, empty if the interface is not generic
+//	[4]: The type arguments, empty if the interface is not generic
+//	[5]: The interface type, qualified if declared in another package
+//	[6]: The name of the wrap function
+//	[7]: The operator turning the wrapper into the receiver type, & for pointer receivers
+//	[8]: The operator of the wrapper parameter type, * if the middleware struct can't be copied
+//	[9]: The statements initializing the unexported fields of the wrapper besides the wrapped value
+//	[10]: The name of the field holding the wrapped value
+const wrapFunctionFormat = `// %[6]s returns the passed %[5]s wrapped in the middleware defined in %[2]s
+func %[6]s%[3]s(toWrap %[5]s%[4]s, wrapper %[8]s%[2]s%[4]s) %[5]s%[4]s {
+	wrapper.%[10]s = toWrap
+%[9]s	return %[7]swrapper
+}
+`
+
+// Format string of the function returning a middleware struct without any middleware,
+// which passes all calls through to the wrapped value
+// The arguments for the format string are:
+//
+//	[1]: The name of the middleware struct
+//	[2]: The type parameter list, empty if the interface is not generic
+//	[3]: The type arguments, empty if the interface is not generic
+//	[4]: The interface type, qualified if declared in another package
+//	[5]: The name of the constructor
+//	[6]: The elements initializing the unexported fields besides the wrapped value, each preceded by a comma
+//	[7]: The name of the field holding the wrapped value
+const newFunctionFormat = `
+// %[5]s returns a %[1]s wrapping the passed %[4]s without any middleware
+func %[5]s%[2]s(wrapped %[4]s%[3]s) *%[1]s%[3]s {
+	return &%[1]s%[3]s{%[7]s: wrapped%[6]s}
+}
+`
+
+// unwrapFormat is the format string of the method returning the wrapped value
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+//	[3]: The interface type, including the type arguments
+//	[4]: The name of the field holding the wrapped value
+const unwrapFormat = `
+// Unwrap returns the wrapped %[3]s
+func (%[1]s %[2]s) Unwrap() %[3]s {
+	return %[1]s.%[4]s
+}
+`
+
+// generateWrapperCode generates the code for the wrapper of the target interface
+func (g *generator) generateWrapperCode() error {
+	g.wrapFunction = new(bytes.Buffer)
+	g.middlewareStruct = new(bytes.Buffer)
+	g.handlerFuncTypes = new(bytes.Buffer)
+	g.helperMethods = new(bytes.Buffer)
+
+	g.structName = fmt.Sprintf("%sMiddleware", g.targetName)
+	g.wrapFuncName = "Wrap" + g.targetName
+	g.newFuncName = "New" + g.structName
+	if g.unexported {
+		// The middleware can't be used outside of the package, so it is kept package-private
+		g.structName = lowerFirst(g.structName)
+		g.wrapFuncName = "wrap" + g.targetName
+		g.newFuncName = "new" + g.targetName + "Middleware"
+	}
+	receiver, err := g.receiverName()
+	if err != nil {
+		return err
+	}
+	g.receiver = receiver
+
+	g.members = make(map[string]string)
+	g.declarePackageLevel(g.structName, g.targetName)
+	g.declarePackageLevel(g.wrapFuncName, g.targetName)
+	g.declarePackageLevel(g.newFuncName, g.targetName)
+	g.wrappedField = "wrapped"
+	if g.opts.WrappedField != "" {
+		g.wrappedField = g.opts.WrappedField
+	}
+	g.declare(g.members, g.wrappedField, g.structName)
+
+	if g.compositeDecl != "" {
+		g.declarePackageLevel(g.targetName, g.targetName)
+		fmt.Fprint(g.wrapFunction, g.compositeDecl)
+	}
+
+	// Write wrap function
+	if g.opts.FunctionalOptions {
+		g.generateOptionType()
+	} else {
+		reference, pointer := "&", ""
+		if g.opts.ValueReceivers {
+			reference = ""
+		}
+		if g.opts.Sync || g.opts.Count || g.opts.LastError {
+			// The mutex must not be copied, so the wrapper is passed by pointer
+			reference, pointer = "", "*"
+		}
+		init := ""
+		for _, field := range g.mapFields() {
+			init += fmt.Sprintf("\twrapper.%s = make(%s)\n", field[0], field[1])
+		}
+		fmt.Fprintf(g.wrapFunction, wrapFunctionFormat, g.targetName, g.structName, g.typeParams, g.typeArgs, g.targetType, g.wrapFuncName, reference, pointer, init, g.wrappedField)
+	}
+
+	// Write header of middleware struct
+	fmt.Fprintf(g.middlewareStruct, "// %s implements %s\n", g.structName, g.targetType)
+	if g.opts.ValueReceivers {
+		fmt.Fprintln(g.middlewareStruct, "//\n// Its methods have value receivers, so a wrapped value holds a copy of the middleware,")
+		fmt.Fprintln(g.middlewareStruct, "// which can't be changed after wrapping and is therefore safe for concurrent use.")
+		fmt.Fprintln(g.middlewareStruct, "// Changes to the middleware struct only affect values wrapped afterwards.")
+	}
+	if g.opts.Sync {
+		fmt.Fprintln(g.middlewareStruct, "//\n// Its middleware can be changed while its methods are called using the setter methods,")
+		fmt.Fprintln(g.middlewareStruct, "// all other fields have to be set before. It must not be copied after first use.")
+	} else if g.opts.Count || g.opts.LastError {
+		fmt.Fprintln(g.middlewareStruct, "//\n// It records the calls of its methods, so it must not be copied after first use.")
+	}
+	fmt.Fprintf(g.middlewareStruct, "type %s%s struct {\n", g.structName, g.typeParams)
+	fmt.Fprintf(g.middlewareStruct, "\t%s %s%s\n", g.wrappedField, g.targetType, g.typeArgs)
+	fmt.Fprintln(g.middlewareStruct)
+
+	if g.opts.StructContext {
+		g.declare(g.members, "Context", g.structName)
+		fmt.Fprintln(g.middlewareStruct, "\t// Context is the context of the middleware, e.g. carrying the request ID of a middleware per request")
+		fmt.Fprintf(g.middlewareStruct, "\tContext %s.Context\n", g.importAs("context", "context"))
+		fmt.Fprintln(g.middlewareStruct)
+	}
+
+	if g.opts.Sync {
+		g.importAs("sync", "sync")
+		g.declare(g.members, "mu", g.structName)
+		fmt.Fprintln(g.middlewareStruct, "\t// mu guards the middleware fields, which are set by the setter methods while the methods are called")
+		fmt.Fprintln(g.middlewareStruct, "\tmu sync.RWMutex")
+		fmt.Fprintln(g.middlewareStruct)
+	}
+
+	if g.opts.Count {
+		g.importAs("sync", "sync")
+		g.declare(g.members, "callCounts", g.structName)
+		g.declare(g.members, "callCountsMu", g.structName)
+		fmt.Fprintln(g.middlewareStruct, "\t// callCounts holds the number of calls per method name, guarded by callCountsMu")
+		fmt.Fprintln(g.middlewareStruct, "\tcallCounts   map[string]uint64")
+		fmt.Fprintln(g.middlewareStruct, "\tcallCountsMu sync.Mutex")
+		fmt.Fprintln(g.middlewareStruct)
+	}
+
+	if g.opts.LastError {
+		g.importAs("sync", "sync")
+		g.declare(g.members, "lastErrors", g.structName)
+		g.declare(g.members, "lastErrorsMu", g.structName)
+		fmt.Fprintln(g.middlewareStruct, "\t// lastErrors holds the last error returned per method name, guarded by lastErrorsMu")
+		fmt.Fprintln(g.middlewareStruct, "\tlastErrors   map[string]error")
+		fmt.Fprintln(g.middlewareStruct, "\tlastErrorsMu sync.Mutex")
+		fmt.Fprintln(g.middlewareStruct)
+	}
+
+	if g.opts.Recover {
+		g.declare(g.members, "OnPanic", g.structName)
+		fmt.Fprintln(g.middlewareStruct, "\t// OnPanic is called with the name of the method and the recovered value when a method panics")
+		fmt.Fprintln(g.middlewareStruct, "\tOnPanic func(method string, recovered any)")
+		fmt.Fprintln(g.middlewareStruct)
+		if g.opts.FunctionalOptions {
+			// The hook isn't specific to a method, so the option is named after the target to be unique
+			g.generateOption(g.targetName+"OnPanic", "OnPanic", "onPanic", "func(method string, recovered any)", false, g.targetName)
+		}
+	}
+
+	g.generateInterfaceMethods(g.target)
+
+	// Write footer of middleware struct
+	fmt.Fprint(g.middlewareStruct, "}\n")
+
+	// Assert that the struct implements the target, so a stale file fails to compile
+	if g.typeParams == "" {
+		fmt.Fprintf(g.middlewareStruct, "\nvar _ %s = (*%s)(nil)\n", g.targetType, g.structName)
+	} else {
+		// Variables can't be generic, so the assertion is instantiated with the type parameters of a function
+		fmt.Fprintf(g.middlewareStruct, "\nfunc _%s() {\n\tvar _ %s%s = (*%s%s)(nil)\n}\n", g.typeParams, g.targetType, g.typeArgs, g.structName, g.typeArgs)
+	}
+
+	init := ""
+	for _, field := range g.mapFields() {
+		init += fmt.Sprintf(", %s: make(%s)", field[0], field[1])
+	}
+	fmt.Fprintf(g.middlewareStruct, newFunctionFormat, g.structName, g.typeParams, g.typeArgs, g.targetType, g.newFuncName, init, g.wrappedField)
+
+	// Interfaces declaring an Unwrap method already forward it to the wrapped value
+	if _, ok := g.members["Unwrap"]; !ok {
+		g.declare(g.members, "Unwrap", g.structName)
+		fmt.Fprintf(g.middlewareStruct, unwrapFormat, g.receiver, g.receiverType(), g.targetType+g.typeArgs, g.wrappedField)
+	}
+
+	if g.opts.Timing {
+		g.generateTimingHelper()
+	}
+	if g.opts.Tracing {
+		g.generateTracingHelper()
+	}
+	if g.opts.Logging {
+		g.generateLoggingHelper()
+	}
+	if g.opts.Metrics {
+		g.generateMetricsHelper()
+	}
+	if g.opts.StructContext {
+		g.generateWithContext()
+	}
+	if g.opts.Builder {
+		g.generateBuilder()
+	}
+	g.generateWrappedMethods()
+	g.generateFuncAdapter()
+	if _, ok := g.members["SetMiddleware"]; !ok && g.opts.ByName {
+		g.generateSetMiddleware()
+	}
+
+	if g.opts.Count {
+		g.declare(g.members, "CallCount", g.structName)
+		fmt.Fprintf(g.helperMethods, callCountFormat, g.receiver, g.structName+g.typeArgs)
+	}
+	if g.opts.LastError {
+		g.declare(g.members, "LastError", g.structName)
+		fmt.Fprintf(g.helperMethods, lastErrorFormat, g.receiver, g.structName+g.typeArgs)
+	}
+
+	return g.err
+}
+
+// interfaceMethodFormat is the format string for the methods implementing the interface
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type, a pointer unless value receivers are generated
+//	[3]: The function name
+//	[4]: The function parameters
+//	[5]: The function return type, empty if the function has no return value
+//	[6]: The function body
+//	[7]: The doc comment of the function
+const interfaceMethodFormat = `%[7]s
+func (%[1]s %[2]s) %[3]s(%[4]s) %[5]s {
+%[6]s}
+
+`
+
+// applyMiddlewareFormat is the format string for the part of a method body
+// which wraps the wrapped function in its middleware
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The arguments passed to the middleware before the handler, e.g. the context
+//	[4]: The statements calling the wrapped function directly if there is no middleware, avoiding the method value
+//	[5]: The method of the wrapped value
+const applyMiddlewareFormat = `	if %[1]s.%[2]sMiddleware == nil {
+		%[4]s
+	}
+	fun := %[1]s.%[2]sMiddleware(%[3]s%[5]s)
+`
+
+// applySyncMiddlewareFormat is the format string for the part of a method body
+// which wraps the wrapped function in its middleware read while holding the lock of the middleware struct
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The arguments passed to the middleware before the handler, e.g. the context
+//	[4]: The statements calling the wrapped function directly if there is no middleware, avoiding the method value
+//	[5]: The method of the wrapped value
+const applySyncMiddlewareFormat = `	%[1]s.mu.RLock()
+	middleware := %[1]s.%[2]sMiddleware
+	%[1]s.mu.RUnlock()
+	if middleware == nil {
+		%[4]s
+	}
+	fun := middleware(%[3]s%[5]s)
+`
+
+// applySyncMiddlewareChainFormat is the format string for the part of a method body
+// which wraps the wrapped function in its chain of middleware read while holding the lock of the middleware struct
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The arguments passed to the middleware before the handler, e.g. the context
+//	[4]: The statements calling the wrapped function directly if there is no middleware, avoiding the method value
+//	[5]: The method of the wrapped value
+const applySyncMiddlewareChainFormat = `	%[1]s.mu.RLock()
+	middleware := %[1]s.%[2]sMiddleware
+	%[1]s.mu.RUnlock()
+	if len(middleware) == 0 {
+		%[4]s
+	}
+	fun := %[5]s
+	for i := len(middleware) - 1; i >= 0; i-- {
+		fun = middleware[i](%[3]sfun)
+	}
+`
+
+// applyMiddlewareChainFormat is the format string for the part of a method body
+// which wraps the wrapped function in its chain of middleware.
+// The middleware is applied from last to first, so the first middleware runs outermost.
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The arguments passed to the middleware before the handler, e.g. the context
+//	[4]: The statements calling the wrapped function directly if there is no middleware, avoiding the method value
+//	[5]: The method of the wrapped value
+const applyMiddlewareChainFormat = `	if len(%[1]s.%[2]sMiddleware) == 0 {
+		%[4]s
+	}
+	fun := %[5]s
+	for i := len(%[1]s.%[2]sMiddleware) - 1; i >= 0; i-- {
+		fun = %[1]s.%[2]sMiddleware[i](%[3]sfun)
+	}
+`
+
+// asyncFormat is the format string for the part of a method body
+// which calls a function in a new goroutine if the method is asynchronous
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The statement calling the function in a new goroutine
+const asyncFormat = `	if %[1]s.%[2]sAsync {
+		%[3]s
+		return
+	}
+`
+
+// toggleFormat is the format string for the part of a method body
+// which returns the zero values of the results if the method is disabled
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The statement returning the zero values
+const toggleFormat = `	if %[1]s.%[2]sDisabled {
+		%[3]s
+	}
+`
+
+// guardFormat is the format string for the part of a method body
+// which returns the zero values of the results if the guard of the method rejects the call
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The arguments of the call
+//	[4]: The statement returning the zero values
+const guardFormat = `	if %[1]s.%[2]sGuard != nil && !%[1]s.%[2]sGuard(%[3]s) {
+		%[4]s
+	}
+`
+
+// beforeHookFormat is the format string for the part of a method body
+// which calls the hook of the method with its arguments before calling it
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The name of the hook
+//	[3]: The arguments of the call
+const beforeHookFormat = `	if %[1]s.%[2]s != nil {
+		%[1]s.%[2]s(%[3]s)
+	}
+`
+
+// afterHookFormat is the format string for the statements calling a function
+// and the hook of the method with its results afterwards
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The name of the hook
+//	[3]: The statement calling the function, assigning its results if there are any
+//	[4]: The results of the call
+//	[5]: The statement returning the results on a new line, empty if there are none
+const afterHookFormat = `%[3]s
+	if %[1]s.%[2]s != nil {
+		%[1]s.%[2]s(%[4]s)
+	}%[5]s`
+
+// aroundFormat is the format string for the statements calling the around callback of a method if it is set,
+// else calling the function directly
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The statements calling the around callback and returning
+//	[4]: The statements calling the function directly
+const aroundFormat = `if %[1]s.%[2]sAround != nil {
+		%[3]s
+	}
+	%[4]s`
+
+// recoverFormat is the format string for the part of a method body
+// which recovers from panics and reports them to the OnPanic hook
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The statement handling the recovered value after calling the hook
+const recoverFormat = `	defer func() {
+		if recovered := recover(); recovered != nil {
+			if %[1]s.OnPanic != nil {
+				%[1]s.OnPanic("%[2]s", recovered)
+			}
+			%[3]s
+		}
+	}()
+`
+
+// countFormat is the format string for the part of a method body counting the call
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+const countFormat = `	%[1]s.callCountsMu.Lock()
+	%[1]s.callCounts["%[2]s"]++
+	%[1]s.callCountsMu.Unlock()
+`
+
+// callCountFormat is the format string for the method returning the number of calls of a method
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+const callCountFormat = `// CallCount returns how often the method with the passed name was called
+func (%[1]s *%[2]s) CallCount(method string) uint64 {
+	%[1]s.callCountsMu.Lock()
+	defer %[1]s.callCountsMu.Unlock()
+	return %[1]s.callCounts[method]
+}
+
+`
+
+// chainMethodsFormat is the format string for the methods appending, prepending and resetting
+// the chain of middleware of a method
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+//	[3]: The function name
+//	[4]: The type of a single middleware
+//	[5]: The statements locking the middleware struct, empty if it isn't synchronized
+const chainMethodsFormat = `// Append%[3]sMiddleware appends the passed middleware to the chain of %[3]s.
+// The chain is applied in order, so earlier middleware wraps later middleware
+// and the first middleware of the chain runs outermost.
+func (%[1]s *%[2]s) Append%[3]sMiddleware(middleware ...%[4]s) {
+%[5]s	%[1]s.%[3]sMiddleware = append(%[1]s.%[3]sMiddleware, middleware...)
+}
+
+// Prepend%[3]sMiddleware prepends the passed middleware to the chain of %[3]s,
+// so it wraps all middleware added before, keeping the order of the passed middleware
+func (%[1]s *%[2]s) Prepend%[3]sMiddleware(middleware ...%[4]s) {
+%[5]s	%[1]s.%[3]sMiddleware = append(append([]%[4]s{}, middleware...), %[1]s.%[3]sMiddleware...)
+}
+
+// Reset%[3]sMiddleware removes all middleware from the chain of %[3]s
+func (%[1]s *%[2]s) Reset%[3]sMiddleware() {
+%[5]s	%[1]s.%[3]sMiddleware = nil
+}
+
+// Add%[3]sMiddleware appends the passed middleware to the chain of %[3]s like Append%[3]sMiddleware
+func (%[1]s *%[2]s) Add%[3]sMiddleware(middleware ...%[4]s) {
+	%[1]s.Append%[3]sMiddleware(middleware...)
+}
+
+`
+
+// setMiddlewareFormat is the format string for the method setting the middleware of a method
+// while holding the lock of the middleware struct
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+//	[3]: The function name
+//	[4]: The type of the middleware
+//	[5]: The statements locking the middleware struct
+const setMiddlewareFormat = `// Set%[3]sMiddleware sets the middleware of %[3]s, it is safe to call concurrently with %[3]s
+func (%[1]s *%[2]s) Set%[3]sMiddleware(middleware %[4]s) {
+%[5]s	%[1]s.%[3]sMiddleware = middleware
+}
+
+`
+
+// getMiddlewareFormat is the format string for the method returning the middleware of a method
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+//	[3]: The function name
+//	[4]: The type of the middleware field
+//	[5]: The statements locking the middleware struct for reading, empty if it isn't synchronized
+//	[6]: The returned expression
+const getMiddlewareFormat = `// Get%[3]sMiddleware returns the current middleware of %[3]s, which is nil if it has none
+func (%[1]s *%[2]s) Get%[3]sMiddleware() %[4]s {
+%[5]s	return %[6]s
+}
+
+`
+
+// generateInterfaceMethods generates the function declarations of
+// the methods required by the wrapper to implement
+func (g *generator) generateInterfaceMethods(target *types.Interface) {
+	for i := 0; i < target.NumMethods(); i++ {
+		fun := target.Method(i)
+		origin := g.methodOrigin(target, fun)
+		m := g.newMethod(fun)
+		g.methods = append(g.methods, m)
+		g.opts.logf("generating method %s, has return value: %t", m.name, m.sig.Results().Len() > 0)
+
+		g.declare(g.members, m.name, origin)
+		if !m.intercepted {
+			// Methods without middleware need neither a handler type nor a field
+			g.generateMiddlewareMethod(m)
+			continue
+		}
+
+		// Generate the handler type, whose name may already be taken by a type of the package
+		if g.err == nil {
+			if g.declarePackageLevel(m.handlerTypeName, origin); g.err != nil {
+				g.err = fmt.Errorf("%w, use another handler format to rename the handler types, e.g. -handler-format='{{.Type}}{{.Method}}Handler'", g.err)
+			}
+		}
+		sigBuf := new(bytes.Buffer)
+		types.WriteSignature(sigBuf, m.sig, g.typeStringQuantifier)
+		sigString, _ := io.ReadAll(sigBuf)
+		fmt.Fprintf(g.handlerFuncTypes, "type %s%s func%s\n", m.handlerTypeName, g.typeParams, string(sigString))
+
+		// Generate the struct field
+		g.declare(g.members, m.fieldName, origin)
+		if g.opts.Chain {
+			for _, prefix := range []string{"Append", "Prepend", "Reset", "Add"} {
+				g.declare(g.members, prefix+m.fieldName, origin)
+			}
+			fmt.Fprintf(g.middlewareStruct, "\t%s []%s\n", m.fieldName, m.middlewareType)
+			fmt.Fprintf(m.code, chainMethodsFormat,
+				g.receiver,
+				g.structName+g.typeArgs,
+				m.name,
+				m.middlewareType,
+				g.lockStatements(),
+			)
+		} else {
+			fmt.Fprintf(g.middlewareStruct, "\t%s %s\n", m.fieldName, m.middlewareType)
+			if g.opts.Sync {
+				g.declare(g.members, "Set"+m.fieldName, origin)
+				fmt.Fprintf(m.code, setMiddlewareFormat,
+					g.receiver,
+					g.structName+g.typeArgs,
+					m.name,
+					m.middlewareType,
+					g.lockStatements(),
+				)
+			}
+		}
+		if g.opts.Getters {
+			g.declare(g.members, "Get"+m.fieldName, origin)
+			fieldType, field := m.middlewareType, g.receiver+"."+m.fieldName
+			if g.opts.Chain {
+				// The chain is copied, so it can't be modified through the returned slice
+				fieldType = "[]" + fieldType
+				field = fmt.Sprintf("append(%s(nil), %s...)", fieldType, field)
+			}
+			fmt.Fprintf(m.code, getMiddlewareFormat,
+				g.receiver,
+				g.structName+g.typeArgs,
+				m.name,
+				fieldType,
+				g.readLockStatements(),
+				field,
+			)
+		}
+		if g.opts.Toggle {
+			g.declare(g.members, m.name+"Disabled", origin)
+			fmt.Fprintf(g.middlewareStruct, "\t%sDisabled bool\n", m.name)
+		}
+		if m.async {
+			g.declare(g.members, m.name+"Async", origin)
+			fmt.Fprintf(g.middlewareStruct, "\t%sAsync bool\n", m.name)
+			if g.opts.FunctionalOptions {
+				g.generateOption(upperFirst(m.name)+"Async", m.name+"Async", "async", "bool", false, origin)
+			}
+		}
+		if g.opts.Hooks {
+			before, after := "Before"+upperFirst(m.name), "After"+upperFirst(m.name)
+			g.declare(g.members, before, origin)
+			g.declare(g.members, after, origin)
+			fmt.Fprintf(g.middlewareStruct, "\t%s func(%s)\n", before, strings.Join(m.paramTypes, ", "))
+			fmt.Fprintf(g.middlewareStruct, "\t%s func(%s)\n", after, strings.Join(m.resultTypes, ", "))
+			if g.opts.FunctionalOptions {
+				g.generateOption(before, before, "hook", fmt.Sprintf("func(%s)", strings.Join(m.paramTypes, ", ")), false, origin)
+				g.generateOption(after, after, "hook", fmt.Sprintf("func(%s)", strings.Join(m.resultTypes, ", ")), false, origin)
+			}
+		}
+		if g.opts.Around {
+			g.declare(g.members, m.name+"Around", origin)
+			fmt.Fprintf(g.middlewareStruct, "\t%sAround %s\n", m.name, m.aroundType())
+			if g.opts.FunctionalOptions {
+				g.generateOption(upperFirst(m.name)+"Around", m.name+"Around", "around", m.aroundType(), false, origin)
+			}
+		}
+		if g.opts.Abortable {
+			g.declare(g.members, m.name+"Guard", origin)
+			fmt.Fprintf(g.middlewareStruct, "\t%sGuard %s\n", m.name, m.guardType())
+			if g.opts.FunctionalOptions {
+				g.generateOption(upperFirst(m.name)+"Guard", m.name+"Guard", "guard", m.guardType(), false, origin)
+			}
+		}
+		if g.opts.FunctionalOptions {
+			g.generateOption(upperFirst(m.fieldName), m.fieldName, "middleware", m.middlewareType, g.opts.Chain, origin)
+		}
+
+		// Generate the middleware method
+		g.generateMiddlewareMethod(m)
+	}
+}
+
+// declare adds the passed identifier to the passed scope of generated identifiers.
+// If the identifier was already declared, generation fails naming both origins.
+func (g *generator) declare(scope map[string]string, name, origin string) {
+	if other, ok := scope[name]; ok && g.err == nil {
+		g.err = fmt.Errorf("generated identifier '%s' for %s collides with the one for %s", name, origin, other)
+	}
+	scope[name] = origin
+}
+
+// declarePackageLevel adds the passed identifier to the package level identifiers of the generated code.
+// Generation also fails if the package of the generated code already declares the identifier
+// outside of previously generated code.
+func (g *generator) declarePackageLevel(name, origin string) {
+	g.declare(g.declared, name, origin)
+
+	if g.packageName != g.p.Name || g.err != nil {
+		return
+	}
+	if obj := g.p.Types.Scope().Lookup(name); obj != nil && !g.isGenerated(obj.Pos()) {
+		g.err = fmt.Errorf("generated identifier '%s' for %s collides with the one declared at %s", name, origin, g.p.Fset.Position(obj.Pos()))
+	}
+}
+
+// generatedHeaderPrefix is the prefix of the first line of files generated by middlewarer
+const generatedHeaderPrefix = "// Code generated by \"middlewarer"
+
+// isGenerated returns whether the passed position is inside of a file previously generated by middlewarer
+func (g *generator) isGenerated(pos token.Pos) bool {
+	tokenFile := g.p.Fset.File(pos)
+	for _, file := range g.p.Syntax {
+		if g.p.Fset.File(file.Pos()) != tokenFile {
+			continue
+		}
+		// The header is written above the package clause, possibly preceded by a build constraint,
+		// while code inlined into a source file follows the inlined marker
+		for _, group := range file.Comments {
+			if group.Pos() > pos {
+				break
+			}
+			for _, comment := range group.List {
+				if strings.HasPrefix(comment.Text, generatedHeaderPrefix) && group.Pos() < file.Package {
+					return true
+				}
+				if strings.HasPrefix(comment.Text, inlinedMarkerPrefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// methodOrigin describes where the passed method of the target interface is declared,
+// naming the embedded interface it stems from if it is not declared by the target itself
+func (g *generator) methodOrigin(target *types.Interface, fun *types.Func) string {
+	origin := fmt.Sprintf("%s.%s", g.targetName, fun.Name())
+
+	for i := 0; i < target.NumExplicitMethods(); i++ {
+		if target.ExplicitMethod(i).Name() == fun.Name() {
+			return origin
+		}
+	}
+
+	for i := 0; i < target.NumEmbeddeds(); i++ {
+		embedded := target.EmbeddedType(i)
+		iFace, ok := embedded.Underlying().(*types.Interface)
+		if !ok {
+			continue
+		}
+		for j := 0; j < iFace.NumMethods(); j++ {
+			if iFace.Method(j).Name() == fun.Name() {
+				return fmt.Sprintf("%s (embedded from %s)", origin, types.TypeString(embedded, types.RelativeTo(g.p.Types)))
+			}
+		}
+	}
+
+	return origin
+}
+
+// lowerFirst returns the passed identifier with its first letter in lowercase
+func lowerFirst(s string) string {
+	first, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToLower(first)) + s[size:]
+}
+
+// lockStatements returns the statements locking the middleware struct until the end of the function,
+// empty if the middleware struct isn't synchronized
+func (g *generator) lockStatements() string {
+	if !g.opts.Sync {
+		return ""
+	}
+	return fmt.Sprintf("\t%[1]s.mu.Lock()\n\tdefer %[1]s.mu.Unlock()\n", g.receiver)
+}
+
+// readLockStatements returns the statements locking the middleware struct for reading until the end of the function,
+// empty if it isn't synchronized
+func (g *generator) readLockStatements() string {
+	if !g.opts.Sync {
+		return ""
+	}
+	return fmt.Sprintf("\t%[1]s.mu.RLock()\n\tdefer %[1]s.mu.RUnlock()\n", g.receiver)
+}
+
+// wrappedMethodsFormat is the format string for the method returning the names of the wrapped methods
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+//	[3]: The quoted method names, separated by commas
+const wrappedMethodsFormat = `// WrappedMethods returns the names of all methods of the wrapped interface in the order of their declaration
+func (%[1]s %[2]s) WrappedMethods() []string {
+	return []string{%[3]s}
+}
+
+`
+
+// generateWrappedMethods generates the WrappedMethods method, unless the interface declares it itself
+func (g *generator) generateWrappedMethods() {
+	if _, ok := g.members["WrappedMethods"]; ok {
+		return
+	}
+	g.declare(g.members, "WrappedMethods", g.structName)
+
+	names := g.declarationOrder()
+	for i, name := range names {
+		names[i] = strconv.Quote(name)
+	}
+	fmt.Fprintf(g.helperMethods, wrappedMethodsFormat, g.receiver, g.receiverType(), strings.Join(names, ", "))
+}
+
+// declarationOrder returns the names of the methods of the target in the order of their declaration,
+// listing the methods of embedded interfaces in place of the embedding and the parts of a composite in order.
+// The methods of interfaces whose declaration isn't loaded are ordered by their position.
+func (g *generator) declarationOrder() []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	var visit func(typ types.Type)
+	visit = func(typ types.Type) {
+		iFace, ok := typ.Underlying().(*types.Interface)
+		if !ok {
+			return
+		}
+		fields := g.interfaceFields(typ)
+		if fields == nil {
+			methods := make([]*types.Func, iFace.NumMethods())
+			for i := range methods {
+				methods[i] = iFace.Method(i)
+			}
+			sort.SliceStable(methods, func(i, j int) bool { return methods[i].Pos() < methods[j].Pos() })
+			for _, fun := range methods {
+				add(fun.Name())
+			}
+			return
+		}
+		// The embedded types of the interface are in the order of the embedded fields
+		embedded := 0
+		for _, field := range fields {
+			if len(field.Names) > 0 {
+				add(field.Names[0].Name)
+				continue
+			}
+			visit(iFace.EmbeddedType(embedded))
+			embedded++
+		}
+	}
+
+	if g.declaration != nil {
+		visit(g.declaration)
+	}
+	for _, part := range g.parts {
+		visit(part)
+	}
+	return names
+}
+
+// interfaceFields returns the fields of the declaration of the passed named interface,
+// or nil if it isn't declared in a loaded file
+func (g *generator) interfaceFields(typ types.Type) []*ast.Field {
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return nil
+	}
+	obj := named.Obj()
+	for _, pkg := range []*packages.Package{g.targetPackage, g.p} {
+		if obj.Pkg() != pkg.Types {
+			continue
+		}
+		for _, file := range pkg.Syntax {
+			var fields []*ast.Field
+			ast.Inspect(file, func(n ast.Node) bool {
+				spec, ok := n.(*ast.TypeSpec)
+				if ok && spec.Name.Pos() == obj.Pos() {
+					if iFace, ok := spec.Type.(*ast.InterfaceType); ok {
+						fields = iFace.Methods.List
+					}
+				}
+				return fields == nil
+			})
+			if fields != nil {
+				return fields
+			}
+		}
+	}
+	return nil
+}
+
+// lastErrorFormat is the format string for the method returning the last error returned by a method
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+const lastErrorFormat = `// LastError returns the last non-nil error returned by the method with the passed name
+func (%[1]s *%[2]s) LastError(method string) error {
+	%[1]s.lastErrorsMu.Lock()
+	defer %[1]s.lastErrorsMu.Unlock()
+	return %[1]s.lastErrors[method]
+}
+
+`
+
+// recordErrorFormat is the format string for the part of a method body
+// which records the error result when the method returns
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The function name
+//	[3]: The name of the error result
+const recordErrorFormat = `	defer func() {
+		if %[3]s != nil {
+			%[1]s.lastErrorsMu.Lock()
+			%[1]s.lastErrors["%[2]s"] = %[3]s
+			%[1]s.lastErrorsMu.Unlock()
+		}
+	}()
+`
+
+// recordsError returns whether the error result of the passed method is recorded,
+// which requires the results to be named
+func (g *generator) recordsError(m *method) bool {
+	return g.opts.LastError && m.errIndex != -1
+}
+
+// mapFields returns the names and types of the map fields of the middleware struct,
+// which have to be initialized by the wrap function and the constructor
+func (g *generator) mapFields() [][2]string {
+	var fields [][2]string
+	if g.opts.Count {
+		fields = append(fields, [2]string{"callCounts", "map[string]uint64"})
+	}
+	if g.opts.LastError {
+		fields = append(fields, [2]string{"lastErrors", "map[string]error"})
+	}
+	return fields
+}
+
+// countStatements returns the statements counting a call of the passed method,
+// empty if calls aren't counted
+func (g *generator) countStatements(m *method) string {
+	if !g.opts.Count {
+		return ""
+	}
+	return fmt.Sprintf(countFormat, g.receiver, m.name)
+}
+
+// receiverType returns the receiver type of the methods implementing the target
+func (g *generator) receiverType() string {
+	if g.opts.ValueReceivers {
+		return g.structName + g.typeArgs
+	}
+	return "*" + g.structName + g.typeArgs
+}
+
+// upperFirst returns the passed identifier with its first letter in uppercase
+func upperFirst(s string) string {
+	first, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(first)) + s[size:]
+}
+
+// receiverName returns the name of the receiver of the generated methods.
+// It is the configured receiver name if set, else the lowercase first letter of the target name,
+// or m if the name doesn't start with a letter.
+// The name mustn't clash with any parameter or result name of the methods of the target,
+// any package referenced by the generated code or any other identifier used in it.
+// A clashing configured name is an error, underscores are appended to a clashing default name until it doesn't clash.
+func (g *generator) receiverName() (string, error) {
+	first, _ := utf8.DecodeRuneInString(g.targetName)
+	receiver := "m"
+	if unicode.IsLetter(first) {
+		receiver = string(unicode.ToLower(first))
+	}
+
+	used := g.reservedNames()
+	for _, name := range []string{"next", "start", "record", "tracer", "ctx", "span", "logger", "metrics", "copied", "method", "fn", "middleware", "onPanic", "guard", "hook", "around", "ok", "async", "wrapped"} {
+		used[name] = true
+	}
+	// The predeclared identifiers referenced by the generated code, e.g. nil, len or error
+	for _, name := range types.Universe.Names() {
+		used[name] = true
+	}
+	// The packages the generated code may reference, by itself or in the signatures of the methods
+	for _, name := range []string{"context", "sync", "trace", "slog"} {
+		used[name] = true
+	}
+	for name := range literalImports {
+		used[name] = true
+	}
+	for _, name := range g.imports {
+		used[name] = true
+	}
+	qualifier := func(p *types.Package) string {
+		if !g.isLocal(p) {
+			used[p.Name()] = true
+		}
+		return p.Name()
+	}
+	for i := 0; i < g.target.NumMethods(); i++ {
+		sig := g.target.Method(i).Type().(*types.Signature)
+		for j := 0; j < sig.Params().Len(); j++ {
+			used[sig.Params().At(j).Name()] = true
+		}
+		for j := 0; j < sig.Results().Len(); j++ {
+			used[sig.Results().At(j).Name()] = true
+		}
+		// The synthetic names of the parameters and results
+		for _, name := range syntheticNames("a", sig.Params().Len()) {
+			used[name] = true
+		}
+		for _, name := range syntheticNames("r", sig.Results().Len()) {
+			used[name] = true
+		}
+		types.TypeString(sig, qualifier)
+		// The handler types are referenced in the method bodies
+		used[g.handlerTypeName(g.target.Method(i).Name())] = true
+	}
+
+	if g.opts.Receiver != "" {
+		if used[g.opts.Receiver] {
+			return "", fmt.Errorf("provided receiver name '%s' clashes with an identifier used by the middleware of %s", g.opts.Receiver, g.targetName)
+		}
+		return g.opts.Receiver, nil
+	}
+	for used[receiver] {
+		receiver += "_"
+	}
+	return receiver, nil
+}
@@ -0,0 +1,539 @@
+package middlewarer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const receiverSource = `package p
+
+import (
+	"context"
+	"time"
+)
+
+type Timer interface {
+	Wait(ctx context.Context, d time.Duration) error
+	Reset(t int)
+}
+`
+
+func TestReceiverName(t *testing.T) {
+	generated := generateSource(t, receiverSource, Options{TypeNames: []string{"Timer"}, Receiver: "mw"})
+	typeCheck(t, receiverSource, generated)
+	assertContains(t, generated,
+		"func (mw *TimerMiddleware) Wait(",
+		"func (mw *TimerMiddleware) Reset(",
+		"mw.wrapped.Wait(",
+	)
+	if strings.Contains(generated, "func (t ") {
+		t.Errorf("generated code still uses the default receiver\n%s", generated)
+	}
+
+	// The default receiver t clashes with the parameter of Reset
+	generated = generateSource(t, receiverSource, Options{TypeNames: []string{"Timer"}})
+	typeCheck(t, receiverSource, generated)
+	assertContains(t, generated, "func (t_ *TimerMiddleware) Wait(")
+}
+
+func TestReceiverNameClash(t *testing.T) {
+	tests := map[string]Options{
+		"fmt":         {},
+		"time":        {},
+		"context":     {},
+		"sync":        {},
+		"next":        {},
+		"d":           {},
+		"a0":          {},
+		"nil":         {},
+		"error":       {},
+		"WaitHandler": {},
+		"ok":          {},
+		"async":       {Async: true, FunctionalOptions: true},
+		"wrapped":     {Builder: true},
+	}
+	for receiver, opts := range tests {
+		opts.TypeNames, opts.Receiver, opts.Source = []string{"Timer"}, receiver, strings.NewReader(receiverSource)
+		err := Generate(new(strings.Builder), opts)
+		if err == nil || !strings.Contains(err.Error(), "clashes") {
+			t.Errorf("receiver %s: expected a clash error, got %v", receiver, err)
+		}
+	}
+}
+
+func TestGenerateFuncResult(t *testing.T) {
+	src := `package p
+
+type Node interface {
+	Children() func() Node
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Node"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type ChildrenHandler func() func() Node",
+		"func (n *NodeMiddleware) Children() func() Node {",
+	)
+	if strings.Contains(generated, "p.Node") {
+		t.Errorf("generated code qualifies the type of its own package\n%s", generated)
+	}
+}
+
+func TestGenerateTypeParam(t *testing.T) {
+	src := `package p
+
+type Store[T any] interface {
+	Put(v T) error
+	All() []T
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Store"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type PutHandler[T any] func(v T) error",
+		"type AllHandler[T any] func() []T",
+		"func (s *StoreMiddleware[T]) Put(a0 T) error {",
+		"func (s *StoreMiddleware[T]) All() []T {",
+	)
+	if strings.Contains(generated, "p.T") {
+		t.Errorf("generated code qualifies the type parameter\n%s", generated)
+	}
+}
+
+func TestGenerateConstraintInterface(t *testing.T) {
+	src := `package p
+
+type Number interface {
+	~int
+	String() string
+}
+`
+	err := Generate(new(strings.Builder), Options{TypeNames: []string{"Number"}, Source: strings.NewReader(src)})
+	if !errors.Is(err, ErrConstraintInterface) {
+		t.Errorf("expected ErrConstraintInterface, got %v", err)
+	}
+}
+
+func TestGenerateGenericVariadic(t *testing.T) {
+	src := `package p
+
+type Logger[T any] interface {
+	Log(level T, args ...any)
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Logger"}, ParamNames: true})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type LogHandler[T any] func(level T, args ...any)",
+		"func (l *LoggerMiddleware[T]) Log(level T, args ...any) {",
+		"l.wrapped.Log(level, args...)",
+		"fun(level, args...)",
+	)
+}
+
+func TestGenerateSelfReference(t *testing.T) {
+	src := `package p
+
+type Node interface {
+	Merge(other Node) Node
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Node"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type MergeHandler func(other Node) Node",
+		"func (n *NodeMiddleware) Merge(a0 Node) Node {",
+		"return n.wrapped.Merge(a0)",
+	)
+	if strings.Contains(generated, "p.Node") {
+		t.Errorf("generated code qualifies the type of its own package\n%s", generated)
+	}
+}
+
+const compositeSource = `package p
+
+type Reader interface {
+	Read(p []byte) (int, error)
+	Close() error
+}
+
+type Writer interface {
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+type Seeker interface {
+	Close()
+}
+`
+
+func TestGenerateComposite(t *testing.T) {
+	named := generateSource(t, compositeSource, Options{TypeNames: []string{"RW=Reader+Writer"}, Args: []string{"-type=RW=Reader+Writer"}})
+	typeCheck(t, compositeSource, named)
+	assertGolden(t, "composite", named)
+
+	unnamed := generateSource(t, compositeSource, Options{TypeNames: []string{"Reader+Writer"}})
+	typeCheck(t, compositeSource, unnamed)
+	assertContains(t, unnamed,
+		"func WrapReaderWriter(toWrap interface {\n\tReader\n\tWriter\n}, wrapper ReaderWriterMiddleware)",
+		"type ReaderWriterMiddleware struct",
+	)
+
+	// Methods declared by several interfaces need identical signatures
+	err := Generate(new(strings.Builder), Options{TypeNames: []string{"Reader+Seeker"}, Source: strings.NewReader(compositeSource)})
+	if err == nil || !strings.Contains(err.Error(), "method Close of Seeker conflicts with the one of Reader") {
+		t.Errorf("expected a conflict of Close, got %v", err)
+	}
+}
+
+func TestGenerateFunctionalOptions(t *testing.T) {
+	src := `package p
+
+import "context"
+
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+	Close() error
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Fetcher"}, FunctionalOptions: true, Args: []string{"-options", "-type=Fetcher"}})
+	typeCheck(t, src, generated)
+	assertGolden(t, "options", generated)
+}
+
+func TestGenerateLogging(t *testing.T) {
+	generated := generateSource(t, featureSource, Options{TypeNames: []string{"Service"}, Logging: true})
+	typeCheck(t, featureSource, generated)
+	assertContains(t, generated,
+		`"log/slog"`,
+		"func (s *ServiceMiddleware) WithLogging(logger *slog.Logger) {",
+		`logger.LogAttrs(ctx, slog.LevelDebug, "calling method", slog.String("method", "Fetch"))`,
+	)
+}
+
+const invalidSource = `package p
+
+type Store interface{ Get() error }
+
+type impl struct{}
+
+func (impl) Get() error { return nil }
+
+type Plain struct{ Handler interface{ Serve() } }
+`
+
+// generateError generates the middleware of the passed type declared in src, returning the error of the generation
+func generateError(src, typeName string) error {
+	return Generate(new(strings.Builder), Options{TypeNames: []string{typeName}, Source: strings.NewReader(src)})
+}
+
+func TestGenerateStruct(t *testing.T) {
+	tests := map[string]string{
+		"impl":  "'impl' is a struct, pass one of the interfaces it implements instead: Store",
+		"Plain": "'Plain' is a struct, the anonymous interfaces of its fields Handler can't be wrapped",
+	}
+	for typeName, message := range tests {
+		err := generateError(invalidSource, typeName)
+		if !errors.Is(err, ErrNotInterface) || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected ErrNotInterface containing %q, got %v", typeName, message, err)
+		}
+	}
+}
+
+func TestGenerateNestedType(t *testing.T) {
+	err := generateError(invalidSource, "Plain.Handler")
+	if !errors.Is(err, ErrTypeNotFound) || !strings.Contains(err.Error(), "declare the interface of Handler as a named type") {
+		t.Errorf("expected ErrTypeNotFound suggesting a named type, got %v", err)
+	}
+}
+
+func TestGenerateSourcePosition(t *testing.T) {
+	err := generateError(invalidSource, "impl")
+	if err == nil || !strings.HasPrefix(err.Error(), "stdin.go:5:6: ") {
+		t.Errorf("expected an error prefixed by the position of impl, got %v", err)
+	}
+}
+
+func TestGenerateEmptyInterface(t *testing.T) {
+	src := `package p
+
+type Empty interface{}
+
+type OnlyEmpty interface {
+	Empty
+	any
+}
+`
+	tests := map[string]string{
+		"Empty":     "'Empty' declares no methods",
+		"OnlyEmpty": "'OnlyEmpty' only embeds the empty interfaces Empty, any",
+	}
+	for typeName, message := range tests {
+		err := generateError(src, typeName)
+		if !errors.Is(err, ErrEmptyInterface) || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected ErrEmptyInterface containing %q, got %v", typeName, message, err)
+		}
+	}
+}
+
+func TestGenerateAlias(t *testing.T) {
+	src := `package p
+
+import "io"
+
+type Store interface{ Get() error }
+
+type Alias = Store
+
+type RC = io.ReadCloser
+`
+	for _, typeName := range []string{"Alias", "RC"} {
+		generated := generateSource(t, src, Options{TypeNames: []string{typeName}})
+		typeCheck(t, src, generated)
+		assertContains(t, generated, "func Wrap"+typeName+"(toWrap "+typeName+", wrapper "+typeName+"Middleware) "+typeName+" {")
+	}
+}
+
+func TestGenerateUnexportedMethods(t *testing.T) {
+	src := `package p
+
+type Inner interface {
+	get() int
+	Put()
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Inner"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"func wrapInner(toWrap Inner, wrapper innerMiddleware) Inner {",
+		"type innerMiddleware struct",
+		"func newInnerMiddleware(wrapped Inner) *innerMiddleware {",
+		"func (i *innerMiddleware) get() int {",
+	)
+}
+
+func TestWrappedMethodsOrder(t *testing.T) {
+	src := `package p
+
+import "io"
+
+type Loader interface{ Load() error }
+
+type Store interface {
+	Put(key string)
+	Loader
+	Delete(key string)
+	io.Closer
+}
+`
+	test := `package p
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWrappedMethods(t *testing.T) {
+	want := []string{"Put", "Load", "Delete", "Close"}
+	if got := NewStoreMiddleware(nil).WrappedMethods(); !reflect.DeepEqual(got, want) {
+		t.Errorf("WrappedMethods() = %v, want the methods in the order of their declaration %v", got, want)
+	}
+}
+`
+	runGenerated(t, src, Options{TypeNames: []string{"Store"}}, test)
+}
+
+func TestChainOrder(t *testing.T) {
+	test := `package p
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var tags []string
+	tag := func(name string) func(GetHandler) GetHandler {
+		return func(next GetHandler) GetHandler {
+			return func(key string) (string, error) {
+				tags = append(tags, name)
+				return next(key)
+			}
+		}
+	}
+
+	mw := NewStoreMiddleware(store{})
+	mw.AppendGetMiddleware(tag("a1"), tag("a2"))
+	mw.PrependGetMiddleware(tag("p1"), tag("p2"))
+	mw.AddGetMiddleware(tag("add"))
+	mw.Get("k")
+	if want := []string{"p1", "p2", "a1", "a2", "add"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("middleware ran in the order %v, want %v", tags, want)
+	}
+
+	tags = nil
+	mw.ResetGetMiddleware()
+	mw.AppendGetMiddleware(tag("after reset"))
+	if value, _ := mw.Get("k"); value != "value of k" {
+		t.Errorf("Get returned %q after resetting its middleware", value)
+	}
+	if want := []string{"after reset"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("middleware ran in the order %v after resetting the chain, want %v", tags, want)
+	}
+}
+` + storeImpl
+	for _, opts := range []Options{{Chain: true}, {Chain: true, Sync: true}} {
+		opts.TypeNames = []string{"Store"}
+		runGenerated(t, storeSource, opts, test)
+	}
+}
+
+func TestCallCount(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestCallCount(t *testing.T) {
+	mw := NewStoreMiddleware(store{})
+	mw.Get("k")
+	mw.Get("k")
+	mw.Put("k", "v")
+	mw.Close()
+
+	for method, want := range map[string]uint64{"Get": 2, "Put": 1, "Close": 1, "Delete": 0} {
+		if count := mw.CallCount(method); count != want {
+			t.Errorf("CallCount(%q) = %d, want %d", method, count, want)
+		}
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Count: true, Exclude: []string{"Close"}}, test)
+}
+
+func TestGetters(t *testing.T) {
+	test := `package p
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetter(t *testing.T) {
+	mw := NewStoreMiddleware(store{})
+	if mw.GetGetMiddleware() != nil {
+		t.Error("GetGetMiddleware of a new middleware isn't nil")
+	}
+
+	set := func(next GetHandler) GetHandler { return next }
+	mw.GetMiddleware = set
+	if got := mw.GetGetMiddleware(); reflect.ValueOf(got).Pointer() != reflect.ValueOf(set).Pointer() {
+		t.Error("GetGetMiddleware doesn't return the middleware set before")
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Getters: true}, test)
+
+	chainTest := `package p
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChainGetter(t *testing.T) {
+	var tags []string
+	tag := func(name string) func(GetHandler) GetHandler {
+		return func(next GetHandler) GetHandler {
+			return func(key string) (string, error) {
+				tags = append(tags, name)
+				return next(key)
+			}
+		}
+	}
+
+	mw := NewStoreMiddleware(store{})
+	mw.AppendGetMiddleware(tag("first"), tag("second"))
+	chain := mw.GetGetMiddleware()
+	if len(chain) != 2 {
+		t.Fatalf("GetGetMiddleware returned %d middleware, want the 2 appended ones", len(chain))
+	}
+
+	chain[0] = tag("replaced")
+	_ = append(chain[:1], tag("appended"))
+	mw.Get("k")
+	if want := []string{"first", "second"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("middleware ran in the order %v after modifying the returned chain, want the unchanged chain %v", tags, want)
+	}
+}
+` + storeImpl
+	for _, opts := range []Options{{Getters: true, Chain: true}, {Getters: true, Chain: true, Sync: true}} {
+		opts.TypeNames = []string{"Store"}
+		runGenerated(t, storeSource, opts, chainTest)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestUnwrap(t *testing.T) {
+	wrapped := new(store)
+	if got := NewStoreMiddleware(wrapped).Unwrap(); got != wrapped {
+		t.Errorf("Unwrap returned %v, want the wrapped value %v", got, wrapped)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}}, test)
+
+	// Interfaces declaring Unwrap themselves keep their method, which calls the wrapped value
+	src := `package p
+
+type Wrapper interface {
+	Unwrap() error
+}
+`
+	ownTest := `package p
+
+import (
+	"errors"
+	"testing"
+)
+
+type wrapper struct{ err error }
+
+func (w wrapper) Unwrap() error { return w.err }
+
+func TestOwnUnwrap(t *testing.T) {
+	err := errors.New("cause")
+	if got := NewWrapperMiddleware(wrapper{err}).Unwrap(); got != err {
+		t.Errorf("Unwrap returned %v, want the result of the wrapped Unwrap %v", got, err)
+	}
+}
+`
+	runGenerated(t, src, Options{TypeNames: []string{"Wrapper"}}, ownTest)
+}
+
+func TestNewMiddlewarePassesThrough(t *testing.T) {
+	test := `package p
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewMiddleware(t *testing.T) {
+	closeErr := errors.New("closed")
+	mw := NewStoreMiddleware(store{closeErr: closeErr})
+	if value, err := mw.Get("k"); value != "value of k" || err != nil {
+		t.Errorf("Get returned %q and %v, want the results of the wrapped Get", value, err)
+	}
+	mw.Put("k", "v")
+	if err := mw.Close(); err != closeErr {
+		t.Errorf("Close returned %v, want the error of the wrapped Close %v", err, closeErr)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}}, test)
+}
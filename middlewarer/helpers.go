@@ -0,0 +1,557 @@
+package middlewarer
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"strings"
+)
+
+// middlewareHelperFormat is the format string for methods setting
+// the middleware of all methods at once
+// The arguments for the format string are:
+//
+//	[1]: The doc comment of the method
+//	[2]: The name of the receiver
+//	[3]: The receiver type
+//	[4]: The name of the method
+//	[5]: The parameters of the method
+//	[6]: The statements setting the middleware
+const middlewareHelperFormat = `%[1]s
+func (%[2]s *%[3]s) %[4]s(%[5]s) {
+%[6]s}
+
+`
+
+// middlewareLiteralFormat is the format string for a function literal
+// which can be used as middleware of a method
+// The arguments for the format string are:
+//
+//	[1]: The parameters of the middleware preceding the handler, e.g. the context
+//	[2]: The handler type
+//	[3]: The parameters of the handler
+//	[4]: The results of the handler
+//	[5]: The body of the returned handler
+const middlewareLiteralFormat = `func(%[1]snext %[2]s) %[2]s {
+	return func(%[3]s) %[4]s {
+%[5]s	}
+}`
+
+// generateMiddlewareHelper generates a method setting the middleware of all methods
+// to the middleware returned by the passed function.
+// The passed function returns the body of the handler returned by the middleware
+// of the passed method, whose parameters are named a0, a1, ... and whose next handler is named next.
+func (g *generator) generateMiddlewareHelper(doc, name, params string, handlerBody func(m *method, args []string) string) {
+	g.declare(g.members, name, g.structName)
+
+	setters := new(bytes.Buffer)
+	setters.WriteString(g.lockStatements())
+	for _, m := range g.methods {
+		if !m.intercepted {
+			continue
+		}
+		args := syntheticNames("a", len(m.paramTypes))
+
+		middlewareParams := ""
+		if m.passesContext {
+			middlewareParams = fmt.Sprintf("_ %s, ", m.paramTypes[0])
+		}
+		literal := fmt.Sprintf(middlewareLiteralFormat,
+			middlewareParams,
+			m.handlerType,
+			m.params(args),
+			m.results(nil),
+			handlerBody(m, args),
+		)
+
+		if g.opts.Chain {
+			fmt.Fprintf(setters, "\t%[1]s.%[2]s = append(%[1]s.%[2]s, %[3]s)\n", g.receiver, m.fieldName, literal)
+		} else {
+			fmt.Fprintf(setters, "\t%s.%s = %s\n", g.receiver, m.fieldName, literal)
+		}
+	}
+
+	fmt.Fprintf(g.helperMethods, middlewareHelperFormat,
+		doc,
+		g.receiver,
+		g.structName+g.typeArgs,
+		name,
+		params,
+		setters.String(),
+	)
+}
+
+// timingHandlerFormat is the format string for the body of a handler
+// measuring the duration of the call to the next handler
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The statement calling the next handler
+const timingHandlerFormat = `		start := time.Now()
+		defer func() {
+			record("%[1]s", time.Since(start))
+		}()
+		%[2]s
+`
+
+// generateTimingHelper generates the WithTiming method, which sets the middleware
+// of all methods to measure the duration of their calls
+func (g *generator) generateTimingHelper() {
+	g.importAs("time", "time")
+
+	doc := "// WithTiming sets the middleware of all methods to measure the duration of their calls,\n// which is passed to record together with the name of the called method"
+	if !g.opts.Chain {
+		doc += ".\n// Any middleware set before is replaced."
+	}
+
+	g.generateMiddlewareHelper(doc, "WithTiming", "record func(method string, d time.Duration)", func(m *method, args []string) string {
+		return fmt.Sprintf(timingHandlerFormat, m.name, m.forward("next", m.args(args)))
+	})
+}
+
+// tracingHandlerFormat is the format string for the body of a handler
+// running the call to the next handler in a span
+// The arguments for the format string are:
+//
+//	[1]: The name of the span
+//	[2]: The context the span is started in
+//	[3]: The name of the context returned by starting the span, _ if it isn't used
+//	[4]: The statements calling the next handler
+const tracingHandlerFormat = `		%[3]s, span := tracer.Start(%[2]s, "%[1]s")
+		defer span.End()
+%[4]s`
+
+// tracingErrorFormat is the format string for the statements calling the next handler
+// and recording its error on the span
+// The arguments for the format string are:
+//
+//	[1]: The results of the next handler
+//	[2]: The call to the next handler
+//	[3]: The error result
+const tracingErrorFormat = `		%[1]s := %[2]s
+		if %[3]s != nil {
+			span.RecordError(%[3]s)
+		}
+		return %[1]s
+`
+
+// generateTracingHelper generates the WithTracing method, which sets the middleware
+// of all methods to run their calls in an OpenTelemetry span
+func (g *generator) generateTracingHelper() {
+	trace := g.importAs("go.opentelemetry.io/otel/trace", "trace")
+
+	doc := "// WithTracing sets the middleware of all methods to run their calls in a span started by tracer,\n// recording the error returned by methods with an error result on it"
+	if !g.opts.Chain {
+		doc += ".\n// Any middleware set before is replaced."
+	}
+
+	g.generateMiddlewareHelper(doc, "WithTracing", "tracer "+trace+".Tracer", func(m *method, args []string) string {
+		// Methods taking a context start the span in it and pass its context on
+		parent, ctx := g.importAs("context", "context")+".Background()", "_"
+		if takesContext(m.sig) {
+			parent, ctx, args = args[0], "ctx", append([]string{"ctx"}, args[1:]...)
+		}
+
+		call := fmt.Sprintf("\t\t%s\n", m.forward("next", m.args(args)))
+		if m.errIndex != -1 {
+			results := syntheticNames("r", len(m.resultTypes))
+			call = fmt.Sprintf(tracingErrorFormat, strings.Join(results, ", "), fmt.Sprintf("next(%s)", m.args(args)), results[m.errIndex])
+		}
+		return fmt.Sprintf(tracingHandlerFormat, g.targetName+"."+m.name, parent, ctx, call)
+	})
+}
+
+// loggingHandlerFormat is the format string for the body of a handler
+// logging the call to the next handler
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The context passed to the logger
+//	[3]: The name of the slog package
+//	[4]: The statements calling the next handler and logging its completion
+const loggingHandlerFormat = `		ctx := %[2]s
+		if logger.Enabled(ctx, %[3]s.LevelDebug) {
+			logger.LogAttrs(ctx, %[3]s.LevelDebug, "calling method", %[3]s.String("method", "%[1]s"))
+		}
+%[4]s`
+
+// loggingReturnFormat is the format string for the statements calling the next handler
+// and logging its return at debug level
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The name of the slog package
+//	[3]: The statement calling the next handler, assigning its results if there are any
+//	[4]: The statement returning the results, empty if there are none
+const loggingReturnFormat = `		%[3]s
+		if logger.Enabled(ctx, %[2]s.LevelDebug) {
+			logger.LogAttrs(ctx, %[2]s.LevelDebug, "method returned", %[2]s.String("method", "%[1]s"))
+		}
+%[4]s`
+
+// loggingErrorFormat is the format string for the statements calling the next handler
+// and logging its error at error level, or its return at debug level if it succeeded
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The name of the slog package
+//	[3]: The results of the next handler
+//	[4]: The call to the next handler
+//	[5]: The error result
+const loggingErrorFormat = `		%[3]s := %[4]s
+		if %[5]s != nil {
+			logger.LogAttrs(ctx, %[2]s.LevelError, "method failed", %[2]s.String("method", "%[1]s"), %[2]s.Any("error", %[5]s))
+		} else if logger.Enabled(ctx, %[2]s.LevelDebug) {
+			logger.LogAttrs(ctx, %[2]s.LevelDebug, "method returned", %[2]s.String("method", "%[1]s"))
+		}
+		return %[3]s
+`
+
+// generateLoggingHelper generates the WithLogging method, which sets the middleware
+// of all methods to log their calls using log/slog
+func (g *generator) generateLoggingHelper() {
+	slog := g.importAs("log/slog", "slog")
+
+	doc := "// WithLogging sets the middleware of all methods to log their calls and returns at debug level\n// and the errors returned by methods with an error result at error level"
+	if !g.opts.Chain {
+		doc += ".\n// Any middleware set before is replaced."
+	}
+
+	g.generateMiddlewareHelper(doc, "WithLogging", "logger *"+slog+".Logger", func(m *method, args []string) string {
+		// Methods taking a context pass it to the logger
+		ctx := g.importAs("context", "context") + ".Background()"
+		if takesContext(m.sig) {
+			ctx = args[0]
+		}
+
+		call := fmt.Sprintf("next(%s)", m.args(args))
+		results := syntheticNames("r", len(m.resultTypes))
+		var calls string
+		switch {
+		case m.errIndex != -1:
+			calls = fmt.Sprintf(loggingErrorFormat, m.name, slog, strings.Join(results, ", "), call, results[m.errIndex])
+		case len(results) == 0:
+			calls = fmt.Sprintf(loggingReturnFormat, m.name, slog, call, "")
+		default:
+			calls = fmt.Sprintf(loggingReturnFormat, m.name, slog, strings.Join(results, ", ")+" := "+call, "\t\treturn "+strings.Join(results, ", ")+"\n")
+		}
+		return fmt.Sprintf(loggingHandlerFormat, m.name, ctx, slog, calls)
+	})
+}
+
+// metricsInterfaceFormat is the format string for the interface receiving the metrics of the calls
+// The arguments for the format string are:
+//
+//	[1]: The name of the interface
+//	[2]: The name of the middleware struct
+const metricsInterfaceFormat = `// %[1]s receives the metrics of the calls to the methods of %[2]s,
+// e.g. to adapt them to a metrics library
+type %[1]s interface {
+	// IncCall is called before every call of a method
+	IncCall(method string)
+	// ObserveDuration is called with the duration of every call of a method
+	ObserveDuration(method string, d time.Duration)
+	// IncError is called if a method with an error result returned a non-nil error
+	IncError(method string)
+}
+
+`
+
+// metricsHandlerFormat is the format string for the body of a handler
+// reporting the metrics of the call to the next handler
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The statements calling the next handler
+const metricsHandlerFormat = `		metrics.IncCall("%[1]s")
+		start := time.Now()
+		defer func() {
+			metrics.ObserveDuration("%[1]s", time.Since(start))
+		}()
+%[2]s`
+
+// metricsErrorFormat is the format string for the statements calling the next handler
+// and counting its error
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The results of the next handler
+//	[3]: The call to the next handler
+//	[4]: The error result
+const metricsErrorFormat = `		%[2]s := %[3]s
+		if %[4]s != nil {
+			metrics.IncError("%[1]s")
+		}
+		return %[2]s
+`
+
+// generateMetricsHelper generates the metrics interface and the WithMetrics method,
+// which sets the middleware of all methods to report their calls, durations and errors to it
+func (g *generator) generateMetricsHelper() {
+	g.importAs("time", "time")
+
+	name := g.targetName + "Metrics"
+	if g.unexported {
+		name = lowerFirst(name)
+	}
+	g.declarePackageLevel(name, g.targetName)
+	fmt.Fprintf(g.helperMethods, metricsInterfaceFormat, name, g.structName)
+
+	doc := "// WithMetrics sets the middleware of all methods to report their calls, their durations\n// and the errors returned by methods with an error result to metrics"
+	if !g.opts.Chain {
+		doc += ".\n// Any middleware set before is replaced."
+	}
+
+	g.generateMiddlewareHelper(doc, "WithMetrics", "metrics "+name, func(m *method, args []string) string {
+		call := fmt.Sprintf("\t\t%s\n", m.forward("next", m.args(args)))
+		if m.errIndex != -1 {
+			results := syntheticNames("r", len(m.resultTypes))
+			call = fmt.Sprintf(metricsErrorFormat, m.name, strings.Join(results, ", "), fmt.Sprintf("next(%s)", m.args(args)), results[m.errIndex])
+		}
+		return fmt.Sprintf(metricsHandlerFormat, m.name, call)
+	})
+}
+
+// setMiddlewareByNameFormat is the format string for the method setting the middleware of a method by its name
+// The arguments for the format string are:
+//
+//	[1]: The doc comment of the method
+//	[2]: The name of the receiver
+//	[3]: The receiver type
+//	[4]: The statements locking the middleware struct, empty if it isn't synchronized
+//	[5]: The cases of the switch over the method names
+const setMiddlewareByNameFormat = `%[1]s
+func (%[2]s *%[3]s) SetMiddleware(method string, fn any) error {
+%[4]s	switch method {
+%[5]s	default:
+		return fmt.Errorf("method %%s has no middleware", method)
+	}
+	return nil
+}
+
+`
+
+// setMiddlewareCaseFormat is the format string for the case of a method in the SetMiddleware method
+// The arguments for the format string are:
+//
+//	[1]: The name of the method
+//	[2]: The type of a single middleware
+//	[3]: The statement setting the middleware
+const setMiddlewareCaseFormat = `	case "%[1]s":
+		middleware, ok := fn.(%[2]s)
+		if !ok {
+			return fmt.Errorf("middleware of %%s has to be a %%T, not a %%T", method, middleware, fn)
+		}
+		%[3]s
+`
+
+// generateSetMiddleware generates the SetMiddleware method, which sets the middleware
+// of a method by its name, e.g. to configure middleware dynamically
+func (g *generator) generateSetMiddleware() {
+	g.importAs("fmt", "fmt")
+	g.declare(g.members, "SetMiddleware", g.structName)
+
+	doc := "// SetMiddleware sets the middleware of the method with the passed name to fn, e.g. to configure middleware by name.\n// It fails if the method has no middleware or fn isn't a middleware of the method."
+	if g.opts.Chain {
+		doc = "// SetMiddleware appends fn to the chain of middleware of the method with the passed name, e.g. to configure middleware by name.\n// It fails if the method has no middleware or fn isn't a middleware of the method."
+	}
+
+	cases := new(bytes.Buffer)
+	for _, m := range g.methods {
+		if !m.intercepted {
+			continue
+		}
+		set := fmt.Sprintf("%s.%s = middleware", g.receiver, m.fieldName)
+		if g.opts.Chain {
+			set = fmt.Sprintf("%[1]s.%[2]s = append(%[1]s.%[2]s, middleware)", g.receiver, m.fieldName)
+		}
+		fmt.Fprintf(cases, setMiddlewareCaseFormat, m.name, m.middlewareType, set)
+	}
+
+	fmt.Fprintf(g.helperMethods, setMiddlewareByNameFormat,
+		doc,
+		g.receiver,
+		g.structName+g.typeArgs,
+		g.lockStatements(),
+		cases.String(),
+	)
+}
+
+// withContextFormat is the format string for the method returning a copy of the middleware struct with another context
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The receiver type
+//	[3]: The name of the context package
+const withContextFormat = `// WithContext returns a shallow copy with its Context set to ctx, e.g. to create a middleware per request.
+// The copy shares the wrapped value and the middleware.
+func (%[1]s *%[2]s) WithContext(ctx %[3]s.Context) *%[2]s {
+	copied := *%[1]s
+	copied.Context = ctx
+	return &copied
+}
+
+`
+
+// generateWithContext generates the WithContext method
+func (g *generator) generateWithContext() {
+	g.declare(g.members, "WithContext", g.structName)
+	fmt.Fprintf(g.helperMethods, withContextFormat, g.receiver, g.structName+g.typeArgs, g.importAs("context", "context"))
+}
+
+// builderFormat is the format string for the builder of the middleware struct
+// The arguments for the format string are:
+//
+//	[1]: The name of the builder
+//	[2]: The name of the middleware struct
+//	[3]: The type parameter list, empty if the interface is not generic
+//	[4]: The type arguments, empty if the interface is not generic
+//	[5]: The name of the constructor of the builder
+//	[6]: The name of the constructor of the middleware struct
+//	[7]: The name of the receiver
+//	[8]: The interface type
+//	[9]: The name of the field holding the wrapped value
+const builderFormat = `// %[1]s builds a %[2]s by chaining its methods setting the middleware
+// and wraps a %[8]s in it by calling Build
+type %[1]s%[3]s struct {
+	middleware *%[2]s%[4]s
+}
+
+// %[5]s returns a %[1]s without any middleware
+func %[5]s%[3]s() *%[1]s%[4]s {
+	return &%[1]s%[4]s{middleware: %[6]s%[4]s(nil)}
+}
+
+// Build returns the passed %[8]s wrapped in the built middleware.
+// The builder must not be used afterwards, as it would change the middleware of the returned value.
+func (%[7]s *%[1]s%[4]s) Build(wrapped %[8]s%[4]s) %[8]s%[4]s {
+	%[7]s.middleware.%[9]s = wrapped
+	return %[7]s.middleware
+}
+
+`
+
+// builderMethodFormat is the format string for the method of the builder setting the middleware of a method
+// The arguments for the format string are:
+//
+//	[1]: The name of the receiver
+//	[2]: The builder type
+//	[3]: The name of the builder method
+//	[4]: The type of the middleware parameter
+//	[5]: The statement setting the middleware
+//	[6]: The doc comment of the method
+const builderMethodFormat = `%[6]s
+func (%[1]s *%[2]s) %[3]s(middleware %[4]s) *%[2]s {
+	%[5]s
+	return %[1]s
+}
+
+`
+
+// generateBuilder generates the builder of the middleware struct and its methods setting the middleware
+func (g *generator) generateBuilder() {
+	name, constructor := g.structName+"Builder", "New"+g.structName+"Builder"
+	if g.unexported {
+		constructor = "new" + g.targetName + "MiddlewareBuilder"
+	}
+	g.declarePackageLevel(name, g.targetName)
+	g.declarePackageLevel(constructor, g.targetName)
+
+	fmt.Fprintf(g.helperMethods, builderFormat,
+		name,
+		g.structName,
+		g.typeParams,
+		g.typeArgs,
+		constructor,
+		g.newFuncName,
+		g.receiver,
+		g.targetType,
+		g.wrappedField,
+	)
+
+	for _, m := range g.methods {
+		if !m.intercepted {
+			continue
+		}
+		method := "With" + upperFirst(m.name)
+		doc := fmt.Sprintf("// %s sets the middleware of %s", method, m.name)
+		paramType, set := m.middlewareType, fmt.Sprintf("%s.middleware.%s = middleware", g.receiver, m.fieldName)
+		if g.opts.Chain {
+			doc = fmt.Sprintf("// %s appends the passed middleware to the chain of %s", method, m.name)
+			paramType, set = "..."+paramType, fmt.Sprintf("%[1]s.middleware.%[2]s = append(%[1]s.middleware.%[2]s, middleware...)", g.receiver, m.fieldName)
+		}
+		fmt.Fprintf(g.helperMethods, builderMethodFormat,
+			g.receiver,
+			name+g.typeArgs,
+			method,
+			paramType,
+			set,
+			doc,
+		)
+	}
+}
+
+// funcAdapterFormat is the format string for the function type implementing a single-method interface,
+// like http.HandlerFunc implements http.Handler
+// The arguments for the format string are:
+//
+//	[1]: The name of the function type
+//	[2]: The type parameter list, empty if the interface is not generic
+//	[3]: The signature of the function type
+//	[4]: The interface type
+//	[5]: The name of the receiver
+//	[6]: The type arguments, empty if the interface is not generic
+//	[7]: The name of the method
+//	[8]: The parameters of the method
+//	[9]: The results of the method
+//	[10]: The statement calling the function
+const funcAdapterFormat = `
+// %[1]s is an adapter allowing the use of ordinary functions as %[4]s.
+// It calls itself when its method %[7]s is called.
+type %[1]s%[2]s func%[3]s
+
+// %[7]s calls %[5]s.
+func (%[5]s %[1]s%[6]s) %[7]s(%[8]s) %[9]s {
+	%[10]s
+}
+`
+
+// generateFuncAdapter generates a function type implementing the target interface if it has a single method,
+// unless the package already declares a type with its name outside of generated code
+func (g *generator) generateFuncAdapter() {
+	if len(g.methods) != 1 {
+		return
+	}
+	m := g.methods[0]
+
+	name := g.targetName + "Func"
+	if g.unexported {
+		name = lowerFirst(name)
+	}
+	if g.packageName == g.p.Name {
+		if obj := g.p.Types.Scope().Lookup(name); obj != nil && !g.isGenerated(obj.Pos()) {
+			return
+		}
+	}
+	g.declarePackageLevel(name, g.targetName)
+
+	receiver := "f"
+	for contains(m.paramNames, receiver) {
+		receiver += "_"
+	}
+
+	sig := new(bytes.Buffer)
+	types.WriteSignature(sig, m.sig, g.typeStringQuantifier)
+	fmt.Fprintf(g.helperMethods, funcAdapterFormat,
+		name,
+		g.typeParams,
+		sig.String(),
+		g.targetType,
+		receiver,
+		g.typeArgs,
+		m.name,
+		m.params(m.paramNames),
+		m.results(nil),
+		m.forward(receiver, m.args(m.paramNames)),
+	)
+}
@@ -0,0 +1,302 @@
+package middlewarer
+
+import "testing"
+
+func TestSetMiddleware(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestSetMiddleware(t *testing.T) {
+	mw := NewStoreMiddleware(store{})
+	err := mw.SetMiddleware("Get", func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			value, err := next(key)
+			return "intercepted " + value, err
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to set the middleware of Get - %v", err)
+	}
+	if value, _ := mw.Get("k"); value != "intercepted value of k" {
+		t.Errorf("Get returned %q, the middleware set by name didn't run", value)
+	}
+
+	tests := map[string]struct {
+		method string
+		fn     any
+		err    string
+	}{
+		"unknown method":  {"Delete", func(next GetHandler) GetHandler { return next }, "method Delete has no middleware"},
+		"excluded method": {"Close", func(next GetHandler) GetHandler { return next }, "method Close has no middleware"},
+		"wrong type": {"Put", func(next GetHandler) GetHandler { return next },
+			"middleware of Put has to be a func(p.PutHandler) p.PutHandler, not a func(p.GetHandler) p.GetHandler"},
+		"nil": {"Get", nil, "middleware of Get has to be a func(p.GetHandler) p.GetHandler, not a <nil>"},
+	}
+	for name, test := range tests {
+		err := mw.SetMiddleware(test.method, test.fn)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: SetMiddleware returned %v, want %q", name, err, test.err)
+		}
+	}
+	if value, _ := mw.Get("k"); value != "intercepted value of k" {
+		t.Errorf("Get returned %q, a failed SetMiddleware changed the middleware", value)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, ByName: true, Exclude: []string{"Close"}}, test)
+}
+
+func TestWithTracing(t *testing.T) {
+	test := `package p
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type recordedSpan struct {
+	name  string
+	ended bool
+	errs  []error
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) { s.ended = true }
+
+func (s *recordedSpan) RecordError(err error, _ ...trace.EventOption) { s.errs = append(s.errs, err) }
+
+type recordingTracer struct{ spans []*recordedSpan }
+
+func (r *recordingTracer) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	span := &recordedSpan{name: name}
+	r.spans = append(r.spans, span)
+	return ctx, span
+}
+
+func TestWithTracing(t *testing.T) {
+	closeErr := errors.New("closed")
+	tracer := new(recordingTracer)
+	mw := NewStoreMiddleware(store{closeErr: closeErr})
+	mw.WithTracing(tracer)
+
+	mw.Get("k")
+	mw.Put("k", "v")
+	if err := mw.Close(); err != closeErr {
+		t.Errorf("Close returned %v, want %v", err, closeErr)
+	}
+
+	names := []string{"Store.Get", "Store.Put", "Store.Close"}
+	if len(tracer.spans) != len(names) {
+		t.Fatalf("started %d spans, want %d", len(tracer.spans), len(names))
+	}
+	for i, span := range tracer.spans {
+		if span.name != names[i] {
+			t.Errorf("span %d is named %q, want %q", i, span.name, names[i])
+		}
+		if !span.ended {
+			t.Errorf("span %s wasn't ended", span.name)
+		}
+	}
+	if errs := tracer.spans[0].errs; len(errs) != 0 {
+		t.Errorf("span of the successful Get recorded the errors %v", errs)
+	}
+	if errs := tracer.spans[2].errs; len(errs) != 1 || errs[0] != closeErr {
+		t.Errorf("span of Close recorded the errors %v, want the returned error %v", errs, closeErr)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Tracing: true}, test)
+}
+
+func TestWithMetrics(t *testing.T) {
+	test := `package p
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeMetrics struct{ calls, durations, errors []string }
+
+func (f *fakeMetrics) IncCall(method string) { f.calls = append(f.calls, method) }
+
+func (f *fakeMetrics) ObserveDuration(method string, _ time.Duration) {
+	f.durations = append(f.durations, method)
+}
+
+func (f *fakeMetrics) IncError(method string) { f.errors = append(f.errors, method) }
+
+func TestWithMetrics(t *testing.T) {
+	metrics := new(fakeMetrics)
+	mw := NewStoreMiddleware(store{})
+	mw.WithMetrics(metrics)
+
+	mw.Get("k")
+	mw.Put("k", "v")
+	mw.Close()
+	mw.wrapped = store{closeErr: errors.New("closed")}
+	mw.Close()
+
+	methods := []string{"Get", "Put", "Close", "Close"}
+	if !reflect.DeepEqual(metrics.calls, methods) {
+		t.Errorf("IncCall was called with %v, want %v", metrics.calls, methods)
+	}
+	if !reflect.DeepEqual(metrics.durations, methods) {
+		t.Errorf("ObserveDuration was called with %v, want %v", metrics.durations, methods)
+	}
+	if want := []string{"Close"}; !reflect.DeepEqual(metrics.errors, want) {
+		t.Errorf("IncError was called with %v, want %v only for the returned error", metrics.errors, want)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Metrics: true}, test)
+}
+
+func TestWithTiming(t *testing.T) {
+	test := `package p
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithTiming(t *testing.T) {
+	var recorded []string
+	mw := NewStoreMiddleware(store{})
+	mw.WithTiming(func(method string, d time.Duration) {
+		if d < 0 {
+			t.Errorf("recorded the negative duration %v for %s", d, method)
+		}
+		recorded = append(recorded, method)
+	})
+
+	if value, _ := mw.Get("k"); value != "value of k" {
+		t.Errorf("Get returned %q, want the value of the wrapped store", value)
+	}
+	mw.Put("k", "v")
+	mw.Close()
+
+	if want := []string{"Get", "Put", "Close"}; !reflect.DeepEqual(recorded, want) {
+		t.Errorf("record was called with %v, want %v", recorded, want)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Timing: true}, test)
+}
+
+func TestWithContext(t *testing.T) {
+	test := `package p
+
+import (
+	"context"
+	"testing"
+)
+
+type key struct{}
+
+func TestWithContext(t *testing.T) {
+	intercepted := 0
+	wrapped := new(store)
+	original := NewStoreMiddleware(wrapped)
+	original.GetMiddleware = func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			intercepted++
+			return next(key)
+		}
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	copied := original.WithContext(ctx)
+	if copied == original {
+		t.Fatal("WithContext returned the original middleware instead of a copy")
+	}
+	if copied.Context != ctx {
+		t.Errorf("the copy carries the context %v, want %v", copied.Context, ctx)
+	}
+	if original.Context != nil {
+		t.Errorf("WithContext changed the context of the original to %v", original.Context)
+	}
+	if copied.wrapped != wrapped {
+		t.Error("the copy doesn't share the wrapped value")
+	}
+	if value, _ := copied.Get("k"); value != "value of k" || intercepted != 1 {
+		t.Errorf("Get of the copy returned %q and ran the middleware %d times, want the shared middleware to run", value, intercepted)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, StructContext: true}, test)
+}
+
+func TestBuilder(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	intercepted := 0
+	mw := func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			intercepted++
+			return next(key)
+		}
+	}
+
+	built := NewStoreMiddlewareBuilder().WithGet(mw).Build(store{})
+	if value, _ := built.Get("k"); value != "value of k" || intercepted != 1 {
+		t.Errorf("Get of the built middleware returned %q and ran the middleware %d times, want it to run once", value, intercepted)
+	}
+	built.Put("k", "v")
+	if intercepted != 1 {
+		t.Errorf("Put ran the middleware of Get")
+	}
+}
+` + storeImpl
+	for _, opts := range []Options{{Builder: true}, {Builder: true, Chain: true}} {
+		opts.TypeNames = []string{"Store"}
+		runGenerated(t, storeSource, opts, test)
+	}
+}
+
+func TestFuncAdapter(t *testing.T) {
+	src := `package p
+
+type Getter interface {
+	Get(key string) (string, error)
+}
+`
+	test := `package p
+
+import "testing"
+
+var _ Getter = GetterFunc(nil)
+
+func TestGetterFunc(t *testing.T) {
+	var called []string
+	f := GetterFunc(func(key string) (string, error) {
+		called = append(called, key)
+		return "value of " + key, nil
+	})
+	if value, _ := f.Get("k"); value != "value of k" || len(called) != 1 || called[0] != "k" {
+		t.Errorf("Get returned %q and called the function with %v, want it to call the function with k", value, called)
+	}
+
+	intercepted := 0
+	wrapped := WrapGetter(f, GetterMiddleware{GetMiddleware: func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			intercepted++
+			return next(key)
+		}
+	}})
+	if value, _ := wrapped.Get("w"); value != "value of w" || intercepted != 1 || len(called) != 2 {
+		t.Errorf("wrapped Get returned %q, ran the middleware %d times and called the function %d times, want each once", value, intercepted, len(called)-1)
+	}
+}
+`
+	runGenerated(t, src, Options{TypeNames: []string{"Getter"}}, test)
+}
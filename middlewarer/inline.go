@@ -0,0 +1,188 @@
+package middlewarer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// inlinedMarkerPrefix is the prefix of the comment preceding code generated by middlewarer inside of a source file
+const inlinedMarkerPrefix = "// Code below generated by \"middlewarer"
+
+// Inline appends the code generated by Generate to the passed Go source file of the same package
+// and formats the result with the passed formatter command like Options.Formatter.
+// The generated declarations are appended after a marker comment. Code previously inlined after the marker is replaced,
+// so inlining the code again doesn't duplicate it.
+// The imports of the source file are updated to the packages referenced by the resulting file,
+// adding the ones of the generated code to its import declaration and dropping the ones no longer used.
+func Inline(src, generated []byte, formatter string) ([]byte, error) {
+	// Drop previously inlined code, which starts at the marker
+	if i := bytes.Index(src, []byte("\n"+inlinedMarkerPrefix)); i != -1 {
+		src = src[:i+1]
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source file - %w", err)
+	}
+	genFile, err := parser.ParseFile(fset, "", generated, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code - %w", err)
+	}
+	if file.Name.Name != genFile.Name.Name {
+		return nil, fmt.Errorf("generated code of package %s can't be inlined into a file of package %s", genFile.Name.Name, file.Name.Name)
+	}
+
+	// The generated declarations follow the package clause and imports of the generated code
+	bodyStart := genFile.Name.End()
+	for _, decl := range genFile.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			bodyStart = gen.End()
+		}
+	}
+	body := generated[fset.Position(bodyStart).Offset:]
+
+	// The marker repeats the arguments of the header of the generated code
+	marker := inlinedMarkerPrefix + "\"; DO NOT EDIT."
+	for _, line := range strings.Split(string(generated[:fset.Position(genFile.Package).Offset]), "\n") {
+		if strings.HasPrefix(line, generatedHeaderPrefix) {
+			marker = inlinedMarkerPrefix + strings.TrimPrefix(line, generatedHeaderPrefix)
+		}
+	}
+
+	res := new(bytes.Buffer)
+	res.Write(bytes.TrimRight(updateImports(fset, file, genFile, src), "\n"))
+	fmt.Fprintf(res, "\n\n%s\n\n", marker)
+	res.Write(bytes.TrimLeft(body, "\n"))
+
+	return formatCode(res.Bytes(), formatter)
+}
+
+// sourceEdit replaces the bytes between start and end of a source file by text
+type sourceEdit struct {
+	start, end int
+	text       string
+}
+
+// updateImports returns the passed source of file with the imports of genFile added to its import declarations
+// and the imports referenced by neither of them removed, except for blank and dot imports.
+// The imports are added to the last import declaration, which is parenthesized if it isn't already.
+func updateImports(fset *token.FileSet, file, genFile *ast.File, src []byte) []byte {
+	used := qualifiers(file)
+	for name := range qualifiers(genFile) {
+		used[name] = true
+	}
+	isUsed := func(spec *ast.ImportSpec) bool {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := importName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		return name == "_" || name == "." || used[name]
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+
+	var edits []sourceEdit
+	imported := make(map[string]bool)
+	var target *ast.GenDecl
+	var kept []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+
+		var declKept []string
+		var removed []sourceEdit
+		for _, spec := range gen.Specs {
+			spec := spec.(*ast.ImportSpec)
+			if isUsed(spec) {
+				imported[importSpecString(spec)] = true
+				declKept = append(declKept, importSpecString(spec))
+				continue
+			}
+			// The whole line of the spec is removed, including its comment
+			start, end := lineStart(src, offset(spec.Pos())), lineEnd(src, offset(spec.End()))
+			removed = append(removed, sourceEdit{start, end, ""})
+		}
+
+		switch {
+		case len(declKept) == 0:
+			edits = append(edits, sourceEdit{offset(gen.Pos()), lineEnd(src, offset(gen.End())), ""})
+		case gen.Lparen.IsValid():
+			edits = append(edits, removed...)
+			target, kept = gen, nil
+		default:
+			target, kept = gen, declKept
+		}
+	}
+
+	var missing []string
+	for _, spec := range genFile.Imports {
+		if s := importSpecString(spec); !imported[s] && isUsed(spec) {
+			imported[s] = true
+			missing = append(missing, s)
+		}
+	}
+	sort.Strings(missing)
+
+	if len(missing) > 0 {
+		switch {
+		case target == nil:
+			// Without imports, the declaration follows the package clause
+			end := offset(file.Name.End())
+			edits = append(edits, sourceEdit{end, end, fmt.Sprintf("\n\nimport (\n\t%s\n)", strings.Join(missing, "\n\t"))})
+		case !target.Lparen.IsValid():
+			// A single import without parentheses is replaced by a parenthesized declaration
+			specs := append(kept, missing...)
+			edits = append(edits, sourceEdit{offset(target.Pos()), offset(target.End()), fmt.Sprintf("import (\n\t%s\n)", strings.Join(specs, "\n\t"))})
+		default:
+			// The imports are inserted on their own lines before the closing parenthesis
+			rparen := offset(target.Rparen)
+			text := "\t" + strings.Join(missing, "\n\t") + "\n"
+			if start := lineStart(src, rparen); len(bytes.TrimSpace(src[start:rparen])) == 0 {
+				edits = append(edits, sourceEdit{start, start, text})
+			} else {
+				edits = append(edits, sourceEdit{rparen, rparen, "\n" + text})
+			}
+		}
+	}
+
+	// The edits are applied from the end, so their offsets stay valid
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	res := append([]byte(nil), src...)
+	for _, edit := range edits {
+		res = append(res[:edit.start], append([]byte(edit.text), res[edit.end:]...)...)
+	}
+	return res
+}
+
+// lineStart returns the offset of the start of the line containing the passed offset
+func lineStart(src []byte, offset int) int {
+	return bytes.LastIndexByte(src[:offset], '\n') + 1
+}
+
+// lineEnd returns the offset after the end of the line containing the passed offset, including its newline
+func lineEnd(src []byte, offset int) int {
+	if i := bytes.IndexByte(src[offset:], '\n'); i != -1 {
+		return offset + i + 1
+	}
+	return len(src)
+}
+
+// importSpecString returns the passed import spec as written in an import declaration
+func importSpecString(spec *ast.ImportSpec) string {
+	path, _ := strconv.Unquote(spec.Path.Value)
+	if spec.Name != nil && spec.Name.Name != importName(path) {
+		return spec.Name.Name + " " + spec.Path.Value
+	}
+	return spec.Path.Value
+}
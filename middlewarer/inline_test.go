@@ -0,0 +1,81 @@
+package middlewarer
+
+import (
+	"strings"
+	"testing"
+)
+
+const inlineSource = `package p
+
+import "fmt"
+
+// Clock tells the time
+type Clock interface {
+	Now() string
+}
+
+func describe(c Clock) string {
+	return fmt.Sprint(c.Now())
+}
+`
+
+// inline inlines the passed generated code into the passed source, failing the test if it can't be inlined
+func inline(t *testing.T, src, generated string) string {
+	t.Helper()
+
+	inlined, err := Inline([]byte(src), []byte(generated), "")
+	if err != nil {
+		t.Fatalf("failed to inline generated code - %v", err)
+	}
+	return string(inlined)
+}
+
+func TestInline(t *testing.T) {
+	generated := generateSource(t, inlineSource, Options{TypeNames: []string{"Clock"}, Timing: true, Args: []string{"-type=Clock", "-timing"}})
+	inlined := inline(t, inlineSource, generated)
+	typeCheck(t, inlined)
+	assertContains(t, inlined,
+		"import (\n\t\"fmt\"\n\t\"time\"\n)",
+		"func describe(c Clock) string",
+		inlinedMarkerPrefix+" -type=Clock -timing\"; DO NOT EDIT.",
+		"func WrapClock(",
+	)
+	if strings.Count(inlined, "import") != 1 {
+		t.Errorf("inlined code has more than one import declaration\n%s", inlined)
+	}
+
+	// Inlining the same code again replaces the previously inlined code
+	if again := inline(t, inlined, generated); again != inlined {
+		t.Errorf("inlining twice changed the code\n%s\nwant\n%s", again, inlined)
+	}
+}
+
+func TestInlineAgain(t *testing.T) {
+	timing := generateSource(t, inlineSource, Options{TypeNames: []string{"Clock"}, Timing: true})
+	plain := generateSource(t, inlineSource, Options{TypeNames: []string{"Clock"}})
+
+	// The import added for the timing helper is dropped when inlining code without it
+	inlined := inline(t, inline(t, inlineSource, timing), plain)
+	typeCheck(t, inlined)
+	if strings.Contains(inlined, `"time"`) || strings.Contains(inlined, "WithTiming") {
+		t.Errorf("inlined code still contains the timing helper\n%s", inlined)
+	}
+}
+
+func TestInlineImports(t *testing.T) {
+	tests := map[string]string{
+		"none":          "package p\n\ntype Clock interface{ Now() string }\n",
+		"single":        "package p\n\nimport \"fmt\"\n\ntype Clock interface{ Now() string }\n\nvar _ = fmt.Sprint\n",
+		"blank":         "package p\n\nimport _ \"embed\"\n\ntype Clock interface{ Now() string }\n",
+		"parenthesized": "package p\n\nimport (\n\t\"fmt\" // formatting\n\t\"os\"\n)\n\ntype Clock interface{ Now() string }\n\nvar _, _ = fmt.Sprint, os.Args\n",
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			generated := generateSource(t, src, Options{TypeNames: []string{"Clock"}, Timing: true})
+			inlined := inline(t, src, generated)
+			typeCheck(t, inlined)
+			assertContains(t, inlined, `"time"`)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package middlewarer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadPackageFunc loads the packages of a generation, tests replace it to observe the loads
+var loadPackageFunc = loadPackage
+
+// loadPackage loads the package matching the passed pattern, relative to the passed directory,
+// selecting the files satisfying the passed build tags. Its syntax is only loaded if requested,
+// else its types are read from the export data of the compiled package, which is considerably faster for large packages.
+func loadPackage(dir, pattern string, buildTags []string, syntax bool) (*packages.Package, error) {
+	conf := &packages.Config{
+		Mode: packages.NeedName | packages.NeedTypes,
+		Dir:  dir,
+	}
+	if syntax {
+		// The syntax is needed for the doc comments and to recognize previously generated files,
+		// it also makes the package type-check from source, so it may contain stale generated code
+		conf.Mode |= packages.NeedSyntax
+	}
+	if len(buildTags) > 0 {
+		conf.BuildFlags = []string{"-tags=" + strings.Join(buildTags, ",")}
+	}
+	packs, err := packages.Load(conf, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages - %w", err)
+	}
+
+	if len(packs) != 1 {
+		return nil, fmt.Errorf("loaded package length is not 1, but %d", len(packs))
+	}
+
+	// Packages which couldn't be found are still returned, but without a name
+	pack := packs[0]
+	if pack.Name == "" {
+		if len(pack.Errors) > 0 {
+			return nil, fmt.Errorf("%w: %s - %s", ErrPackageNotFound, pattern, pack.Errors[0].Msg)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrPackageNotFound, pattern)
+	}
+	return pack, nil
+}
+
+// loadSource parses and type-checks the Go source read from the passed reader
+func loadSource(r io.Reader) (*packages.Package, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source - %w", err)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "stdin.go", src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source - %w", err)
+	}
+
+	var typeErrors []string
+	conf := types.Config{
+		Importer: importer.Default(),
+		Error: func(err error) {
+			typeErrors = append(typeErrors, err.Error())
+		},
+	}
+	pkg, _ := conf.Check(file.Name.Name, fset, []*ast.File{file}, nil)
+	if len(typeErrors) != 0 {
+		return nil, fmt.Errorf("failed to type-check source:\n%s", strings.Join(typeErrors, "\n"))
+	}
+
+	return &packages.Package{
+		ID:      pkg.Path(),
+		Name:    pkg.Name(),
+		PkgPath: pkg.Path(),
+		Fset:    fset,
+		Syntax:  []*ast.File{file},
+		Types:   pkg,
+	}, nil
+}
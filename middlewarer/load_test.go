@@ -0,0 +1,116 @@
+package middlewarer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// countLoads replaces the loader of the packages for the rest of the test,
+// returning the number of loads of every pattern
+func countLoads(t testing.TB) map[string]int {
+	loads := make(map[string]int)
+	load := loadPackageFunc
+	loadPackageFunc = func(dir, pattern string, buildTags []string, syntax bool) (*packages.Package, error) {
+		loads[pattern]++
+		return load(dir, pattern, buildTags, syntax)
+	}
+	t.Cleanup(func() { loadPackageFunc = load })
+	return loads
+}
+
+// interfacePackage writes a package declaring n interfaces I0, I1, ... to a temporary directory,
+// returning the directory and the names of the interfaces
+func interfacePackage(t testing.TB, n int) (string, []string) {
+	src := new(strings.Builder)
+	src.WriteString("package p\n")
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("I%d", i)
+		fmt.Fprintf(src, "\ntype %s interface {\n\tDo%d(n int) (string, error)\n}\n", names[i], i)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/p\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "p.go"), []byte(src.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, names
+}
+
+func TestGenerateLoadsOnce(t *testing.T) {
+	dir, names := interfacePackage(t, 20)
+	loads := countLoads(t)
+
+	// The package declaring io.Closer, io.Reader and io.Writer is loaded once for all of them
+	typeNames := append(names, "io#Closer", "RW=io#Reader+io#Writer")
+	if err := Generate(io.Discard, Options{Dir: dir, TypeNames: typeNames}); err != nil {
+		t.Fatal(err)
+	}
+	if loads["."] != 1 || loads["io"] != 1 || len(loads) != 2 {
+		t.Errorf("expected the package and io to be loaded once, got %v", loads)
+	}
+}
+
+// BenchmarkGenerate compares generating the middleware of 20 interfaces of a package at once,
+// which loads the package once, to generating it separately per interface
+func BenchmarkGenerate(b *testing.B) {
+	dir, names := interfacePackage(b, 20)
+
+	b.Run("once", func(b *testing.B) {
+		loads := countLoads(b)
+		for i := 0; i < b.N; i++ {
+			if err := Generate(io.Discard, Options{Dir: dir, TypeNames: names}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if loads["."] != b.N {
+			b.Fatalf("expected %d loads of the package, got %d", b.N, loads["."])
+		}
+	})
+	b.Run("separately", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, name := range names {
+				if err := Generate(io.Discard, Options{Dir: dir, TypeNames: []string{name}}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkLoadPackage compares loading a large package with its syntax to loading it from its export data
+func BenchmarkLoadPackage(b *testing.B) {
+	for name, syntax := range map[string]bool{"syntax": true, "export": false} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := loadPackage(".", "net/http", nil, syntax); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateNoDoc(t *testing.T) {
+	src := `package p
+
+type Greeter interface {
+	// Greet greets the passed name
+	Greet(name string) string
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Greeter"}})
+	assertContains(t, generated, "// Greet greets the passed name")
+
+	generated = generateSource(t, src, Options{TypeNames: []string{"Greeter"}, NoDoc: true})
+	typeCheck(t, src, generated)
+	assertContains(t, generated, "// Greet implements Greeter.Greet.")
+}
@@ -0,0 +1,68 @@
+package middlewarer
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// metadata describes the generated middleware of all interfaces of a file
+type metadata struct {
+	Package    string              `json:"package"`
+	Interfaces []interfaceMetadata `json:"interfaces"`
+}
+
+// interfaceMetadata describes the generated middleware of an interface
+type interfaceMetadata struct {
+	Name        string           `json:"name"`
+	PackagePath string           `json:"packagePath"`
+	Struct      string           `json:"struct"`
+	Wrap        string           `json:"wrap"`
+	Methods     []methodMetadata `json:"methods"`
+}
+
+// methodMetadata describes a method of an interface.
+// The types are rendered as in the generated code.
+type methodMetadata struct {
+	Name        string   `json:"name"`
+	Params      []string `json:"params"`
+	Results     []string `json:"results"`
+	Variadic    bool     `json:"variadic"`
+	Intercepted bool     `json:"intercepted"`
+}
+
+// writeMetadata writes the JSON metadata describing the code generated by the passed generators
+func writeMetadata(w io.Writer, generators []*generator) error {
+	res := metadata{
+		Package:    generators[0].packageName,
+		Interfaces: make([]interfaceMetadata, len(generators)),
+	}
+	for i, g := range generators {
+		iFace := interfaceMetadata{
+			Name:        g.targetName,
+			PackagePath: g.targetPackage.PkgPath,
+			Struct:      g.structName,
+			Wrap:        g.wrapFuncName,
+			Methods:     make([]methodMetadata, len(g.methods)),
+		}
+		for j, m := range g.methods {
+			params := append([]string{}, m.paramTypes...)
+			if last := len(params) - 1; m.sig.Variadic() {
+				// The variadic parameter is a slice, which is marked by Variadic instead
+				params[last] = "[]" + strings.TrimPrefix(params[last], "...")
+			}
+			iFace.Methods[j] = methodMetadata{
+				Name:        m.name,
+				Params:      params,
+				Results:     append([]string{}, m.resultTypes...),
+				Variadic:    m.sig.Variadic(),
+				Intercepted: m.intercepted,
+			}
+		}
+		res.Interfaces[i] = iFace
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(res)
+}
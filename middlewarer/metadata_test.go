@@ -0,0 +1,47 @@
+package middlewarer
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateMetadata(t *testing.T) {
+	src := `package p
+
+type Printer interface {
+	Printf(format string, args ...any) (int, error)
+	Close() error
+	Each(fn func(xs ...int)) error
+}
+`
+	out := new(bytes.Buffer)
+	opts := Options{TypeNames: []string{"Printer"}, Exclude: []string{"Close"}, Metadata: out, Source: strings.NewReader(src)}
+	if err := Generate(new(bytes.Buffer), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var res metadata
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Fatalf("failed to decode metadata - %v\n%s", err, out)
+	}
+	want := metadata{
+		Package: "p",
+		Interfaces: []interfaceMetadata{{
+			Name:        "Printer",
+			PackagePath: "p",
+			Struct:      "PrinterMiddleware",
+			Wrap:        "WrapPrinter",
+			Methods: []methodMetadata{
+				{Name: "Close", Params: []string{}, Results: []string{"error"}},
+				{Name: "Each", Params: []string{"func(xs ...int)"}, Results: []string{"error"}, Intercepted: true},
+				{Name: "Printf", Params: []string{"string", "[]any"}, Results: []string{"int", "error"}, Variadic: true, Intercepted: true},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("metadata = %+v, want %+v", res, want)
+	}
+}
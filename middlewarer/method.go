@@ -0,0 +1,546 @@
+package middlewarer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// method holds the parts of a method of the target interface needed to generate code for it
+type method struct {
+	name string
+	sig  *types.Signature
+	doc  string // The doc comment of the generated method
+
+	handlerTypeName string // The name of the handler type
+	handlerType     string // The handler type, including the type arguments
+	fieldName       string // The name of the middleware field in the middleware struct
+	middlewareType  string // The type of a single middleware of this method
+
+	paramNames  []string // The names of the parameters in the generated method
+	paramTypes  []string // The types of the parameters, the last one starting with ... if variadic
+	resultTypes []string // The types of the results
+
+	errIndex      int  // The index of the error result, -1 if there is none
+	passesContext bool // Whether the leading context.Context is passed to the middleware
+	intercepted   bool // Whether calls pass through middleware, else they are forwarded to the wrapped value directly
+	async         bool // Whether calls can be dispatched to a new goroutine, which requires the method to have no results
+
+	code *bytes.Buffer // The generated methods belonging to this method, kept apart so they can be split into their own file
+}
+
+// newMethod collects the parts needed to generate code for the passed method
+func (g *generator) newMethod(fun *types.Func) *method {
+	m := &method{
+		name:            fun.Name(),
+		sig:             fun.Type().(*types.Signature),
+		handlerTypeName: g.handlerTypeName(fun.Name()),
+		fieldName:       fmt.Sprintf("%sMiddleware", fun.Name()),
+		passesContext:   g.passesContext(fun),
+		intercepted:     g.opts.intercepts(fun.Name()),
+		doc:             g.methodDoc(fun),
+		code:            new(bytes.Buffer),
+	}
+	m.handlerType = m.handlerTypeName + g.typeArgs
+	m.async = g.opts.Async && m.intercepted && m.sig.Results().Len() == 0
+	m.errIndex = errorResultIndex(m.sig)
+	m.paramNames = g.paramNames(m.sig)
+
+	for i := 0; i < m.sig.Params().Len(); i++ {
+		param := m.sig.Params().At(i)
+		typeString := types.TypeString(param.Type(), g.typeStringQuantifier)
+
+		// The last parameter of a variadic method has to be declared with ...
+		if m.sig.Variadic() && i == m.sig.Params().Len()-1 {
+			elem := param.Type().(*types.Slice).Elem()
+			typeString = "..." + types.TypeString(elem, g.typeStringQuantifier)
+		}
+		m.paramTypes = append(m.paramTypes, typeString)
+	}
+
+	for i := 0; i < m.sig.Results().Len(); i++ {
+		m.resultTypes = append(m.resultTypes, types.TypeString(m.sig.Results().At(i).Type(), g.typeStringQuantifier))
+	}
+
+	m.middlewareType = fmt.Sprintf("func(%[1]s) %[1]s", m.handlerType)
+	if m.passesContext {
+		m.middlewareType = fmt.Sprintf("func(ctx %s, next %[2]s) %[2]s", m.paramTypes[0], m.handlerType)
+	}
+
+	return m
+}
+
+// methodDoc returns the doc comment of the passed method as declared in the interface.
+// If the declaration isn't available or has no doc comment, a comment referring to the interface method is returned.
+func (g *generator) methodDoc(fun *types.Func) string {
+	// Only the syntax of the package declaring the target is loaded
+	if fun.Pkg() == g.targetPackage.Types && !g.opts.NoDoc {
+		for _, file := range g.targetPackage.Syntax {
+			var doc *ast.CommentGroup
+			ast.Inspect(file, func(n ast.Node) bool {
+				field, ok := n.(*ast.Field)
+				if ok && len(field.Names) > 0 && field.Names[0].Pos() == fun.Pos() {
+					doc = field.Doc
+				}
+				return doc == nil
+			})
+
+			if doc != nil {
+				lines := make([]string, len(doc.List))
+				for i, comment := range doc.List {
+					lines[i] = comment.Text
+				}
+				return strings.Join(lines, "\n")
+			}
+		}
+	}
+
+	// The methods of composites are referenced through the interface declaring them
+	for _, part := range g.parts {
+		if obj, _, _ := types.LookupFieldOrMethod(part, false, fun.Pkg(), fun.Name()); obj != nil {
+			return fmt.Sprintf("// %s implements %s.%[1]s.", fun.Name(), types.TypeString(part, g.typeStringQuantifier))
+		}
+	}
+	return fmt.Sprintf("// %s implements %s.%[1]s.", fun.Name(), g.targetType)
+}
+
+// handlerTypeName returns the name of the handler type of the named method, following the handler format
+func (g *generator) handlerTypeName(method string) string {
+	name := new(bytes.Buffer)
+	err := g.handlerFormat.Execute(name, struct{ Type, Method string }{g.targetName, method})
+	if err == nil && !token.IsIdentifier(name.String()) {
+		err = fmt.Errorf("'%s' is not a valid identifier", name)
+	}
+	if err != nil && g.err == nil {
+		g.err = fmt.Errorf("failed to name the handler type of %s.%s - %w", g.targetName, method, err)
+	}
+	return name.String()
+}
+
+// aroundType returns the type of the around callback of the method,
+// which is called with the handler proceeding with the call and the arguments
+func (m *method) aroundType() string {
+	params := m.handlerType
+	if len(m.paramTypes) > 0 {
+		params += ", " + strings.Join(m.paramTypes, ", ")
+	}
+	return fmt.Sprintf("func(%s) %s", params, m.results(nil))
+}
+
+// guardType returns the type of the guard deciding whether the method is called
+func (m *method) guardType() string {
+	return fmt.Sprintf("func(%s) bool", strings.Join(m.paramTypes, ", "))
+}
+
+// syntheticNames returns n names consisting of the passed prefix and their index
+func syntheticNames(prefix string, n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return names
+}
+
+// params returns the parameter list of the method using the passed names
+func (m *method) params(names []string) string {
+	params := make([]string, len(names))
+	for i, name := range names {
+		params[i] = name
+		// Consecutive parameters of the same type share it, e.g. a0, a1 int
+		if i == len(names)-1 || m.paramTypes[i] != m.paramTypes[i+1] {
+			params[i] += " " + m.paramTypes[i]
+		}
+	}
+	return strings.Join(params, ", ")
+}
+
+// args returns the passed names as arguments to the method, spreading the variadic parameter
+func (m *method) args(names []string) string {
+	args := strings.Join(names, ", ")
+	if m.sig.Variadic() {
+		args += "..."
+	}
+	return args
+}
+
+// results returns the result list of the method.
+// If names is nil, the results are unnamed.
+func (m *method) results(names []string) string {
+	results := make([]string, len(m.resultTypes))
+	for i, typeString := range m.resultTypes {
+		results[i] = typeString
+		if names != nil {
+			results[i] = names[i] + " " + typeString
+		}
+	}
+
+	if len(results) > 1 || (len(results) == 1 && names != nil) {
+		return "(" + strings.Join(results, ", ") + ")"
+	}
+	return strings.Join(results, ", ")
+}
+
+// forward returns the statement calling the passed function with the passed arguments,
+// returning its results if the method has any
+func (m *method) forward(fun, args string) string {
+	if len(m.resultTypes) == 0 {
+		return fmt.Sprintf("%s(%s)", fun, args)
+	}
+	return fmt.Sprintf("return %s(%s)", fun, args)
+}
+
+// generateMiddlewareMethod generates the code needed by the method implementation of the function
+func (g *generator) generateMiddlewareMethod(m *method) {
+	if !m.intercepted {
+		g.generateForwardingMethod(m)
+		return
+	}
+
+	middlewareArguments := ""
+	if m.passesContext {
+		middlewareArguments = m.paramNames[0] + ", "
+	}
+
+	// The results have to be named if they are set after recovering from a panic or recorded
+	// The results have to be named as well if they are passed to the hook
+	resultNames := g.resultNames(m.sig, m.paramNames, (g.opts.Recover && m.errIndex != -1) || g.recordsError(m) || g.opts.Hooks)
+
+	body := new(bytes.Buffer)
+	body.WriteString(g.countStatements(m))
+	if g.recordsError(m) {
+		// Deferred before recovering, so errors converted from panics are recorded as well
+		fmt.Fprintf(body, recordErrorFormat, g.receiver, m.name, resultNames[m.errIndex])
+	}
+	if g.opts.Toggle {
+		fmt.Fprintf(body, toggleFormat, g.receiver, m.name, g.zeroReturn(m))
+	}
+	if g.opts.Recover {
+		// Panics are converted to the error result if there is one, else they are propagated
+		handlePanic := "panic(recovered)"
+		if m.errIndex != -1 {
+			g.importAs("fmt", "fmt")
+			handlePanic = fmt.Sprintf("%s = fmt.Errorf(\"panic in %s: %%v\", recovered)", resultNames[m.errIndex], m.name)
+		}
+		fmt.Fprintf(body, recoverFormat, g.receiver, m.name, handlePanic)
+	}
+	if g.opts.Abortable {
+		fmt.Fprintf(body, guardFormat, g.receiver, m.name, m.args(m.paramNames), g.zeroReturn(m))
+	}
+	if g.opts.Hooks {
+		fmt.Fprintf(body, beforeHookFormat, g.receiver, "Before"+upperFirst(m.name), m.args(m.paramNames))
+	}
+	// Without middleware, the wrapped value is called directly
+	direct := g.call(m, g.wrappedMethod(m), resultNames)
+	if len(m.resultTypes) == 0 {
+		direct += "\n\t\treturn"
+	}
+	if m.async {
+		direct = g.asyncStatements(m, g.wrappedMethod(m)) + direct
+	}
+	switch {
+	case g.opts.Sync && g.opts.Chain:
+		fmt.Fprintf(body, applySyncMiddlewareChainFormat, g.receiver, m.name, middlewareArguments, direct, g.wrappedMethod(m))
+	case g.opts.Sync:
+		fmt.Fprintf(body, applySyncMiddlewareFormat, g.receiver, m.name, middlewareArguments, direct, g.wrappedMethod(m))
+	case g.opts.Chain:
+		fmt.Fprintf(body, applyMiddlewareChainFormat, g.receiver, m.name, middlewareArguments, direct, g.wrappedMethod(m))
+	default:
+		fmt.Fprintf(body, applyMiddlewareFormat, g.receiver, m.name, middlewareArguments, direct, g.wrappedMethod(m))
+	}
+	if m.async {
+		body.WriteString(g.asyncStatements(m, "fun"))
+	}
+	fmt.Fprintf(body, "\t%s\n", g.call(m, "fun", resultNames))
+
+	fmt.Fprintf(m.code, interfaceMethodFormat,
+		g.receiver,
+		g.receiverType(),
+		m.name,
+		m.params(m.paramNames),
+		m.results(resultNames),
+		body.String(),
+		m.doc,
+	)
+}
+
+// call returns the statements calling the passed function with the arguments of the passed method and returning its results.
+// If around callbacks are generated, the callback of the method is called instead if set, proceeding with the passed function.
+func (g *generator) call(m *method, fun string, resultNames []string) string {
+	args := m.args(m.paramNames)
+	call := g.returnResults(m, fmt.Sprintf("%s(%s)", fun, args), resultNames)
+	if !g.opts.Around {
+		return call
+	}
+
+	aroundArgs := fun
+	if args != "" {
+		aroundArgs += ", " + args
+	}
+	around := g.returnResults(m, fmt.Sprintf("%s.%sAround(%s)", g.receiver, m.name, aroundArgs), resultNames)
+	if len(m.resultTypes) == 0 {
+		around += "\n\treturn"
+	}
+	return fmt.Sprintf(aroundFormat, g.receiver, m.name, around, call)
+}
+
+// returnResults returns the statements evaluating the passed call of the passed method and returning its results.
+// If hooks are generated, the results are assigned to the passed result names and passed to the After hook before returning.
+func (g *generator) returnResults(m *method, call string, resultNames []string) string {
+	if !g.opts.Hooks {
+		if len(m.resultTypes) == 0 {
+			return call
+		}
+		return "return " + call
+	}
+	results, ret := strings.Join(resultNames, ", "), ""
+	if len(resultNames) > 0 {
+		call = results + " = " + call
+		ret = "\n\treturn " + results
+	}
+	return fmt.Sprintf(afterHookFormat, g.receiver, "After"+upperFirst(m.name), call, results, ret)
+}
+
+// asyncStatements returns the statements calling the passed function in a new goroutine and returning
+// if the passed method is asynchronous. Panics in the goroutine are reported to the OnPanic hook if recovering.
+func (g *generator) asyncStatements(m *method, fun string) string {
+	dispatch := fmt.Sprintf("go %s(%s)", fun, m.args(m.paramNames))
+	if g.opts.Recover {
+		dispatch = fmt.Sprintf("go func() {\n%s\t%s(%s)\n}()", fmt.Sprintf(recoverFormat, g.receiver, m.name, "panic(recovered)"), fun, m.args(m.paramNames))
+	}
+	return fmt.Sprintf(asyncFormat, g.receiver, m.name, dispatch)
+}
+
+// generateForwardingMethod generates the implementation of a method without middleware,
+// which forwards all calls to the wrapped value
+func (g *generator) generateForwardingMethod(m *method) {
+	resultNames := g.resultNames(m.sig, m.paramNames, g.recordsError(m))
+	body := g.countStatements(m)
+	if g.recordsError(m) {
+		body += fmt.Sprintf(recordErrorFormat, g.receiver, m.name, resultNames[m.errIndex])
+	}
+	fmt.Fprintf(m.code, interfaceMethodFormat,
+		g.receiver,
+		g.receiverType(),
+		m.name,
+		m.params(m.paramNames),
+		m.results(resultNames),
+		body+fmt.Sprintf("\t%s\n", m.forward(g.wrappedMethod(m), m.args(m.paramNames))),
+		m.doc,
+	)
+}
+
+// passesContext returns whether the context of the passed method is passed to its middleware.
+// This is the case if enabled and the first parameter of the method is a context.Context.
+func (g *generator) passesContext(fun *types.Func) bool {
+	return g.opts.Context && takesContext(fun.Type().(*types.Signature))
+}
+
+// takesContext returns whether the first parameter of the passed signature is a context.Context
+func takesContext(sig *types.Signature) bool {
+	params := sig.Params()
+	if params.Len() == 0 {
+		return false
+	}
+
+	named, ok := params.At(0).Type().(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
+// errorResultIndex returns the index of the error result of the passed signature,
+// which is the last result of type error if there are several.
+// If there is no such result, -1 is returned.
+func errorResultIndex(sig *types.Signature) int {
+	results := sig.Results()
+	for i := results.Len() - 1; i >= 0; i-- {
+		if isError(results.At(i).Type()) {
+			return i
+		}
+	}
+	return -1
+}
+
+// errorResultCount returns the number of results of type error of the passed signature
+func errorResultCount(sig *types.Signature) int {
+	count := 0
+	for i := 0; i < sig.Results().Len(); i++ {
+		if isError(sig.Results().At(i).Type()) {
+			count++
+		}
+	}
+	return count
+}
+
+// isError returns whether the passed type is the error type
+func isError(typ types.Type) bool {
+	return types.Identical(typ, types.Universe.Lookup("error").Type())
+}
+
+// wrappedMethod returns the method expression of the passed method of the wrapped value
+func (g *generator) wrappedMethod(m *method) string {
+	return g.receiver + "." + g.wrappedField + "." + m.name
+}
+
+// reservedNames returns the identifiers used inside the generated method bodies,
+// including the referenced packages, which must not be used for parameters or results
+func (g *generator) reservedNames() map[string]bool {
+	reserved := map[string]bool{"fun": true}
+	if g.opts.Chain {
+		reserved["i"] = true
+	}
+	if g.opts.Sync {
+		reserved["middleware"] = true
+	}
+	if g.opts.Recover {
+		reserved["recovered"] = true
+		reserved["fmt"] = true
+	}
+	return reserved
+}
+
+// paramNames returns the names used for the parameters of the passed signature.
+// By default the parameters are named a0, a1, ...
+// If enabled, the names of the interface declaration are used instead,
+// falling back to aN for unnamed, blank or duplicate parameters.
+func (g *generator) paramNames(sig *types.Signature) []string {
+	names := make([]string, sig.Params().Len())
+
+	// Names which are already used inside the generated method body
+	used := g.reservedNames()
+
+	// Keep the declared names first, so that synthetic names never shadow them
+	if g.opts.ParamNames {
+		for i := 0; i < sig.Params().Len(); i++ {
+			name := sig.Params().At(i).Name()
+			if name == "" || name == "_" || used[name] {
+				continue
+			}
+			names[i] = name
+			used[name] = true
+		}
+	}
+
+	for i := range names {
+		if names[i] != "" {
+			continue
+		}
+		name := fmt.Sprintf("a%d", i)
+		for used[name] {
+			name += "_"
+		}
+		names[i] = name
+		used[name] = true
+	}
+
+	return names
+}
+
+// resultNames returns the names used for the results of the passed signature.
+// If all results are named in the interface declaration, these names are used.
+// Otherwise the results are left unnamed, returning nil, unless forced to be named.
+// Unnamed, blank or clashing results are named r0, r1, ... instead.
+func (g *generator) resultNames(sig *types.Signature, paramNames []string, force bool) []string {
+	if sig.Results().Len() == 0 {
+		return nil
+	}
+
+	declared := true
+	for i := 0; i < sig.Results().Len(); i++ {
+		if sig.Results().At(i).Name() == "" {
+			declared = false
+		}
+	}
+	if !declared && !force {
+		return nil
+	}
+
+	names := make([]string, sig.Results().Len())
+
+	used := g.reservedNames()
+	for _, name := range paramNames {
+		used[name] = true
+	}
+
+	// Keep the declared names first, so that synthetic names never shadow them
+	if declared {
+		for i := 0; i < sig.Results().Len(); i++ {
+			name := sig.Results().At(i).Name()
+			if name == "_" || used[name] {
+				continue
+			}
+			names[i] = name
+			used[name] = true
+		}
+	}
+
+	for i := range names {
+		if names[i] != "" {
+			continue
+		}
+		name := fmt.Sprintf("r%d", i)
+		for used[name] {
+			name += "_"
+		}
+		names[i] = name
+		used[name] = true
+	}
+
+	return names
+}
+
+// inaccessibleTypes returns the sorted names of the unexported types of other packages
+// referenced by the signatures of the methods, which can't be referenced by the generated code
+func (g *generator) inaccessibleTypes() []string {
+	found := make(map[string]bool)
+	var visit func(typ types.Type)
+	visit = func(typ types.Type) {
+		switch t := typ.(type) {
+		case *types.Named:
+			if obj := t.Obj(); !obj.Exported() && obj.Pkg() != nil && !g.isLocal(obj.Pkg()) {
+				found[obj.Pkg().Name()+"."+obj.Name()] = true
+			}
+			for i := 0; i < t.TypeArgs().Len(); i++ {
+				visit(t.TypeArgs().At(i))
+			}
+		case *types.Pointer:
+			visit(t.Elem())
+		case *types.Slice:
+			visit(t.Elem())
+		case *types.Array:
+			visit(t.Elem())
+		case *types.Chan:
+			visit(t.Elem())
+		case *types.Map:
+			visit(t.Key())
+			visit(t.Elem())
+		case *types.Struct:
+			for i := 0; i < t.NumFields(); i++ {
+				visit(t.Field(i).Type())
+			}
+		case *types.Tuple:
+			for i := 0; i < t.Len(); i++ {
+				visit(t.At(i).Type())
+			}
+		case *types.Signature:
+			visit(t.Params())
+			visit(t.Results())
+		}
+	}
+	for _, m := range g.methods {
+		visit(m.sig)
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,494 @@
+package middlewarer
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+// methodSignature returns the signature of the method M of the interface I declared in the passed source
+func methodSignature(t *testing.T, src string) *types.Signature {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		t.Fatalf("failed to parse source - %v", err)
+	}
+	pkg, err := new(types.Config).Check("p", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("failed to type-check source - %v", err)
+	}
+	iFace := pkg.Scope().Lookup("I").Type().Underlying().(*types.Interface)
+	return iFace.Method(0).Type().(*types.Signature)
+}
+
+func TestErrorResultIndex(t *testing.T) {
+	tests := []struct {
+		results string
+		index   int
+		count   int
+	}{
+		{"", -1, 0},
+		{"int", -1, 0},
+		{"error", 0, 1},
+		{"(int, error)", 1, 1},
+		{"(error, error)", 1, 2},
+		{"(int, error, string)", 1, 1},
+		{"(error, error, string)", 1, 2},
+		{"(err error, n int)", 0, 1},
+	}
+
+	for _, test := range tests {
+		sig := methodSignature(t, "package p\ntype I interface{ M() "+test.results+" }")
+		if index := errorResultIndex(sig); index != test.index {
+			t.Errorf("errorResultIndex of %q = %d, want %d", test.results, index, test.index)
+		}
+		if count := errorResultCount(sig); count != test.count {
+			t.Errorf("errorResultCount of %q = %d, want %d", test.results, count, test.count)
+		}
+	}
+}
+
+func TestGenerateSeveralErrors(t *testing.T) {
+	src := `package p
+
+type Multi interface {
+	Both() (error, error)
+	Middle() (int, error, string)
+}
+`
+	warnings := new(bytes.Buffer)
+	generated := generateSource(t, src, Options{TypeNames: []string{"Multi"}, Recover: true, LastError: true, Warnings: warnings})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		`r1 = fmt.Errorf("panic in Both: %v", recovered)`,
+		`r1 = fmt.Errorf("panic in Middle: %v", recovered)`,
+	)
+
+	if !strings.Contains(warnings.String(), "methods Both of Multi return several errors") {
+		t.Errorf("missing warning about the several errors of Both, got %q", warnings.String())
+	}
+	if strings.Contains(warnings.String(), "Middle") {
+		t.Errorf("unexpected warning about Middle, got %q", warnings.String())
+	}
+}
+
+func TestGenerateBlankParam(t *testing.T) {
+	src := `package p
+
+type Skipper interface {
+	Skip(_ int, x string)
+}
+`
+	for _, paramNames := range []bool{false, true} {
+		generated := generateSource(t, src, Options{TypeNames: []string{"Skipper"}, ParamNames: paramNames})
+		typeCheck(t, src, generated)
+		if strings.Contains(generated, "(_,") || strings.Contains(generated, "Skip(_") {
+			t.Errorf("generated code references the blank parameter\n%s", generated)
+		}
+	}
+
+	generated := generateSource(t, src, Options{TypeNames: []string{"Skipper"}, ParamNames: true})
+	assertContains(t, generated,
+		"func (s *SkipperMiddleware) Skip(a0 int, x string) {",
+		"s.wrapped.Skip(a0, x)",
+		"fun(a0, x)",
+	)
+}
+
+func TestGenerateDeprecated(t *testing.T) {
+	src := `package p
+
+type Legacy interface {
+	// Old does it the old way.
+	//
+	// Deprecated: use New instead.
+	Old()
+	New()
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Legacy"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"// Old does it the old way.\n//\n// Deprecated: use New instead.\nfunc (l *LegacyMiddleware) Old() {",
+		"// New implements Legacy.New.\nfunc (l *LegacyMiddleware) New() {",
+	)
+}
+
+func TestGenerateCompositeTypes(t *testing.T) {
+	src := `package p
+
+type Processor interface {
+	Process(buf [][]byte, sizes [4]int) error
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Processor"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type ProcessHandler func(buf [][]byte, sizes [4]int) error",
+		"func (p *ProcessorMiddleware) Process(a0 [][]byte, a1 [4]int) error {",
+		"return p.wrapped.Process(a0, a1)",
+		"return fun(a0, a1)",
+	)
+}
+
+func TestGenerateNamedResult(t *testing.T) {
+	src := `package p
+
+type Closer interface {
+	Close() (err error)
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Closer"}, ParamNames: true})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type CloseHandler func() (err error)",
+		"func (c *CloserMiddleware) Close() (err error) {",
+	)
+}
+
+func TestGenerateChannels(t *testing.T) {
+	src := `package p
+
+type Feed interface {
+	Pipe(in chan<- int, out <-chan string, both chan bool) (<-chan int, chan<- string, chan bool)
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Feed"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type PipeHandler func(in chan<- int, out <-chan string, both chan bool) (<-chan int, chan<- string, chan bool)",
+		"func (f *FeedMiddleware) Pipe(a0 chan<- int, a1 <-chan string, a2 chan bool) (<-chan int, chan<- string, chan bool) {",
+	)
+}
+
+func TestGenerateImportedStructParam(t *testing.T) {
+	src := `package p
+
+import "log/slog"
+
+type Configurable interface {
+	Configure(opts slog.HandlerOptions) error
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Configurable"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"\"log/slog\"",
+		"type ConfigureHandler func(opts slog.HandlerOptions) error",
+		"func (c *ConfigurableMiddleware) Configure(a0 slog.HandlerOptions) error {",
+	)
+}
+
+func TestGenerateVariadic(t *testing.T) {
+	src := `package p
+
+type Printer interface {
+	Printf(format string, args ...any) (int, error)
+	Print(args ...[]byte)
+}
+`
+	for _, opts := range []Options{{}, {Chain: true}, {Hooks: true}, {Around: true}, {Async: true}} {
+		opts.TypeNames = []string{"Printer"}
+		generated := generateSource(t, src, opts)
+		typeCheck(t, src, generated)
+	}
+
+	generated := generateSource(t, src, Options{TypeNames: []string{"Printer"}})
+	assertContains(t, generated,
+		"type PrintfHandler func(format string, args ...any) (int, error)",
+		"func (p *PrinterMiddleware) Printf(a0 string, a1 ...any) (int, error) {",
+		"return p.wrapped.Printf(a0, a1...)",
+		"return fun(a0, a1...)",
+		"func (p *PrinterMiddleware) Print(a0 ...[]byte) {",
+		"fun(a0...)",
+	)
+}
+
+const selectSource = `package p
+
+type Store interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+}
+`
+
+func TestGenerateExclude(t *testing.T) {
+	warnings := new(bytes.Buffer)
+	generated := generateSource(t, selectSource, Options{TypeNames: []string{"Store"}, Exclude: []string{"Put", "Pt"}, Warnings: warnings})
+	typeCheck(t, selectSource, generated)
+	assertContains(t, generated,
+		"func (s *StoreMiddleware) Put(a0, a1 string) error {\n\treturn s.wrapped.Put(a0, a1)\n}",
+		"GetMiddleware func(GetHandler) GetHandler",
+	)
+	if strings.Contains(generated, "PutMiddleware") {
+		t.Errorf("generated code has middleware of the excluded method\n%s", generated)
+	}
+	if !strings.Contains(warnings.String(), "excluded method Pt is not declared") {
+		t.Errorf("missing warning about the misspelled method, got %q", warnings.String())
+	}
+}
+
+func TestGenerateInclude(t *testing.T) {
+	warnings := new(bytes.Buffer)
+	generated := generateSource(t, selectSource, Options{TypeNames: []string{"Store"}, Include: []string{"Get", "Gt"}, Warnings: warnings})
+	typeCheck(t, selectSource, generated)
+	assertContains(t, generated,
+		"func (s *StoreMiddleware) Put(a0, a1 string) error {\n\treturn s.wrapped.Put(a0, a1)\n}",
+		"GetMiddleware func(GetHandler) GetHandler",
+	)
+	if !strings.Contains(warnings.String(), "included method Gt is not declared") {
+		t.Errorf("missing warning about the misspelled method, got %q", warnings.String())
+	}
+
+	err := Generate(new(bytes.Buffer), Options{TypeNames: []string{"Store"}, Include: []string{"Get"}, Exclude: []string{"Put"}, Source: strings.NewReader(selectSource)})
+	if err == nil {
+		t.Error("including and excluding methods didn't fail")
+	}
+}
+
+func TestGenerateHandlerFormat(t *testing.T) {
+	generated := generateSource(t, selectSource, Options{TypeNames: []string{"Store"}, HandlerFormat: "{{.Type}}{{.Method}}Func"})
+	typeCheck(t, selectSource, generated)
+	assertContains(t, generated,
+		"type StoreGetFunc func(key string) (string, error)",
+		"GetMiddleware func(StoreGetFunc) StoreGetFunc",
+	)
+
+	// Printf-style patterns are formatted with the method name, avoiding the collision with the declared GetHandler
+	src := selectSource + "\ntype GetHandler struct{}\n"
+	generated = generateSource(t, src, Options{TypeNames: []string{"Store"}, HandlerFormat: "%sFunc"})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"type GetFunc func(key string) (string, error)",
+		"GetMiddleware func(GetFunc) GetFunc",
+	)
+
+	for _, format := range []string{"{{.Foo}", "{{.Method}}-Handler", "%s-Handler", "%dHandler", "Handler"} {
+		err := Generate(new(bytes.Buffer), Options{TypeNames: []string{"Store"}, HandlerFormat: format, Source: strings.NewReader(selectSource)})
+		if err == nil {
+			t.Errorf("handler format %q didn't fail", format)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	test := `package p
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var events []string
+	mw := NewStoreMiddleware(store{})
+	mw.GetMiddleware = func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			events = append(events, "middleware")
+			return next(key)
+		}
+	}
+	mw.BeforeGet = func(key string) {
+		events = append(events, "before")
+		if key != "k" {
+			t.Errorf("BeforeGet was called with %q, want the argument k", key)
+		}
+	}
+	mw.AfterGet = func(value string, err error) {
+		events = append(events, "after")
+		if value != "value of k" || err != nil {
+			t.Errorf("AfterGet was called with %q and %v, want the results of Get", value, err)
+		}
+	}
+	mw.Get("k")
+	if len(events) != 3 || events[0] != "before" || events[1] != "middleware" || events[2] != "after" {
+		t.Errorf("the hooks and the middleware ran in the order %v, want before, middleware and after", events)
+	}
+
+	closeErr := errors.New("closed")
+	mw.wrapped = store{closeErr: closeErr}
+	var closed error
+	mw.AfterClose = func(err error) { closed = err }
+	mw.Close()
+	if closed != closeErr {
+		t.Errorf("AfterClose was called with %v, want the returned error %v", closed, closeErr)
+	}
+
+	var put []string
+	mw.BeforePut = func(key, value string) { put = append(put, key, value) }
+	mw.Put("k", "v")
+	if len(put) != 2 || put[0] != "k" || put[1] != "v" {
+		t.Errorf("BeforePut was called with %v, want the arguments k and v", put)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Hooks: true}, test)
+}
+
+func TestAround(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestAround(t *testing.T) {
+	proceeded := 0
+	mw := NewStoreMiddleware(store{})
+	mw.GetMiddleware = func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			proceeded++
+			return next(key)
+		}
+	}
+
+	mw.GetAround = func(proceed GetHandler, key string) (string, error) {
+		return "cached " + key, nil
+	}
+	if value, _ := mw.Get("k"); value != "cached k" || proceeded != 0 {
+		t.Errorf("Get returned %q and proceeded %d times, want the result of GetAround without proceeding", value, proceeded)
+	}
+
+	mw.GetAround = func(proceed GetHandler, key string) (string, error) {
+		value, err := proceed("rewritten " + key)
+		return value + " overridden", err
+	}
+	if value, _ := mw.Get("k"); value != "value of rewritten k overridden" || proceeded != 1 {
+		t.Errorf("Get returned %q and proceeded %d times, want the overridden result of proceeding once", value, proceeded)
+	}
+
+	mw.GetAround = nil
+	if value, _ := mw.Get("k"); value != "value of k" || proceeded != 2 {
+		t.Errorf("Get returned %q without GetAround, want the value of the wrapped store", value)
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Around: true}, test)
+}
+
+func TestAsync(t *testing.T) {
+	test := `package p
+
+import (
+	"testing"
+	"time"
+)
+
+type blockingStore struct {
+	store
+	release, done chan struct{}
+}
+
+func (b blockingStore) Put(key, value string) {
+	<-b.release
+	close(b.done)
+}
+
+func TestAsync(t *testing.T) {
+	wrapped := blockingStore{release: make(chan struct{}), done: make(chan struct{})}
+	mw := NewStoreMiddleware(wrapped)
+	mw.PutAsync = true
+
+	returned := make(chan struct{})
+	go func() {
+		mw.Put("k", "v")
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("asynchronous Put didn't return while the wrapped Put blocks")
+	}
+
+	close(wrapped.release)
+	select {
+	case <-wrapped.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("asynchronous Put didn't call the wrapped Put")
+	}
+}
+` + storeImpl
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Async: true}, test)
+}
+
+// countingStore is a test file declaration counting the calls of the wrapped Get
+const countingStore = `
+type countingStore struct {
+	store
+	gets int
+}
+
+func (c *countingStore) Get(key string) (string, error) {
+	c.gets++
+	return c.store.Get(key)
+}
+`
+
+func TestGuard(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestGuard(t *testing.T) {
+	wrapped := new(countingStore)
+	intercepted := 0
+	mw := NewStoreMiddleware(wrapped)
+	mw.GetMiddleware = func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			intercepted++
+			return next(key)
+		}
+	}
+	mw.GetGuard = func(key string) bool { return key == "allowed" }
+
+	if value, err := mw.Get("denied"); value != "" || err != nil {
+		t.Errorf("rejected Get returned %q and %v, want zero values", value, err)
+	}
+	if intercepted != 0 || wrapped.gets != 0 {
+		t.Errorf("rejected Get ran the middleware %d times and the wrapped Get %d times, want neither", intercepted, wrapped.gets)
+	}
+
+	if value, _ := mw.Get("allowed"); value != "value of allowed" || intercepted != 1 || wrapped.gets != 1 {
+		t.Errorf("accepted Get returned %q, ran the middleware %d times and the wrapped Get %d times, want both once", value, intercepted, wrapped.gets)
+	}
+}
+` + storeImpl + countingStore
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Abortable: true}, test)
+}
+
+func TestToggle(t *testing.T) {
+	test := `package p
+
+import "testing"
+
+func TestToggle(t *testing.T) {
+	wrapped := new(countingStore)
+	intercepted := 0
+	mw := NewStoreMiddleware(wrapped)
+	mw.GetMiddleware = func(next GetHandler) GetHandler {
+		return func(key string) (string, error) {
+			intercepted++
+			return next(key)
+		}
+	}
+
+	mw.GetDisabled = true
+	if value, err := mw.Get("k"); value != "" || err != nil {
+		t.Errorf("disabled Get returned %q and %v, want zero values", value, err)
+	}
+	if intercepted != 0 || wrapped.gets != 0 {
+		t.Errorf("disabled Get ran the middleware %d times and the wrapped Get %d times, want neither", intercepted, wrapped.gets)
+	}
+
+	mw.GetDisabled = false
+	if value, _ := mw.Get("k"); value != "value of k" || wrapped.gets != 1 {
+		t.Errorf("enabled Get returned %q and called the wrapped Get %d times, want its value", value, wrapped.gets)
+	}
+}
+` + storeImpl + countingStore
+	runGenerated(t, storeSource, Options{TypeNames: []string{"Store"}, Toggle: true}, test)
+}
@@ -0,0 +1,509 @@
+// Package middlewarer generates a middleware framework for Go interfaces.
+//
+// For every interface, a middleware struct implementing the interface is generated,
+// which holds a middleware function per method and forwards all calls
+// through the middleware to a wrapped instance of the interface.
+package middlewarer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"go/token"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Errors returned by Generate, wrapped with details about the failure
+var (
+	// ErrPackageNotFound is returned if a package to load couldn't be found
+	ErrPackageNotFound = errors.New("package not found")
+	// ErrTypeNotFound is returned if a type to wrap isn't declared in the package
+	ErrTypeNotFound = errors.New("type not found")
+	// ErrNotInterface is returned if a type to wrap isn't an interface
+	ErrNotInterface = errors.New("type is not an interface")
+	// ErrEmptyInterface is returned if an interface to wrap has no methods
+	ErrEmptyInterface = errors.New("interface is empty")
+	// ErrConstraintInterface is returned if an interface to wrap contains a type set, e.g. a union,
+	// and can therefore only be used as a type constraint
+	ErrConstraintInterface = errors.New("interface is a type constraint")
+)
+
+// Options configures the generation of middleware
+type Options struct {
+	// TypeNames are the names of the interfaces to wrap, which are all generated into the same file.
+	// A name of the form import/path#Type refers to the type declared in the package with that import path.
+	TypeNames []string
+	// Dir is the directory of the package the code is generated for, default is the current directory
+	Dir string
+	// BuildTags are the build tags the packages are loaded with, e.g. "linux" to find interfaces
+	// declared in files constrained to it
+	BuildTags []string
+	// Source is read as the Go source declaring the interfaces instead of loading the package in Dir, if set
+	Source io.Reader
+	// PackagePath is the import path of the package declaring the interfaces, default is the package in Dir
+	PackagePath string
+	// PackageName is the package name of the generated code, default is the name of the package in Dir
+	PackageName string
+	// LicenseHeader is written verbatim at the top of the generated file, before the build constraint, if set
+	LicenseHeader string
+	// BuildConstraint is the expression of a //go:build constraint of the generated file, e.g. "!prod", if set
+	BuildConstraint string
+	// NoBanner omits the "Code generated ... DO NOT EDIT." comment, e.g. if the code is maintained manually afterwards.
+	// Without it, the code can't be generated again while the previous file exists, as its declarations are considered hand-written.
+	NoBanner bool
+	// Args are the arguments of the invocation, which are recorded in the header of the generated code
+	Args []string
+	// Formatter is the command the generated code is piped through to format it, e.g. "goimports" or "gofumpt".
+	// If empty, the code is formatted with go/format, which is also used if goimports isn't installed.
+	Formatter string
+	// Metadata receives a JSON document describing the generated middleware of all interfaces, if set
+	Metadata io.Writer
+	// Verbose receives a log of the found interfaces and their methods, if set
+	Verbose io.Writer
+	// Warnings receives warnings about questionable options, e.g. excluded methods not declared by any interface, if set
+	Warnings io.Writer
+	// Exclude are the names of methods which are forwarded to the wrapped value without any middleware
+	Exclude []string
+	// Include are the names of the only methods with middleware, all others are forwarded to the wrapped value, if set
+	Include []string
+
+	// HandlerFormat is the text/template of the handler type names, executed with the fields Type and Method,
+	// or a printf-style pattern formatted with the method name if it contains no action, default is "%sHandler"
+	HandlerFormat string
+	// Receiver is the name of the receiver of the generated methods, default is the lowercase first letter of the type.
+	// It mustn't clash with any other name used by the methods, including the names of the packages they reference.
+	// Underscores are appended to the default name if it clashes.
+	Receiver string
+	// WrappedField is the name of the field of the middleware struct holding the wrapped value,
+	// default is wrapped
+	WrappedField string
+	// ValueReceivers generates methods with value receivers and wraps values in a copy of the middleware struct
+	// instead of a pointer to it. Methods modifying the middleware struct keep pointer receivers.
+	ValueReceivers bool
+	// ParamNames uses the parameter names of the interface methods instead of a0, a1, ...
+	ParamNames bool
+	// NoDoc documents the generated methods as implementing the interface methods instead of copying their doc comments,
+	// so packages declaring the interfaces outside of the package of the generated code are loaded without their syntax
+	NoDoc bool
+	// Context passes the context.Context of methods taking one as their first parameter to their middleware
+	Context bool
+	// Chain holds a chain of middleware per method instead of a single middleware
+	Chain bool
+	// Recover recovers from panics in the wrapped methods, reporting them to the OnPanic hook
+	Recover bool
+	// Toggle generates a <Method>Disabled field per method, which makes the method return zero values
+	// without calling the wrapped value if set
+	Toggle bool
+	// Async generates a <Method>Async field for every method without results,
+	// making the method call the middleware and the wrapped value in a new goroutine if set
+	Async bool
+	// Abortable generates a <Method>Guard field per method, which is called with the arguments before the middleware.
+	// If it returns false, the call is aborted and the method returns zero values.
+	Abortable bool
+	// Hooks generates a Before<Method> and an After<Method> field per method,
+	// which are called with the arguments before and the results after every call if set
+	Hooks bool
+	// Around generates a <Method>Around field per method, which is called with the handler running the middleware
+	// and the wrapped value and the arguments if set, so it can alter both the arguments and the results
+	Around bool
+	// Sync guards the middleware fields with a mutex and generates setter methods locking it,
+	// so the middleware can be changed while the methods are called. It requires pointer receivers.
+	Sync bool
+	// Getters generates a Get<Method>Middleware method per method returning its current middleware,
+	// e.g. to inspect it in tests
+	Getters bool
+	// ByName generates a SetMiddleware method setting the middleware of a method by its name,
+	// which fails for unknown methods and middleware of the wrong type, e.g. to configure middleware dynamically
+	ByName bool
+	// Count counts the calls of every method, which are returned by a generated CallCount method.
+	// It requires pointer receivers.
+	Count bool
+	// LastError records the last error returned by every method with an error result,
+	// which is returned by a generated LastError method. It requires pointer receivers.
+	LastError bool
+	// StructContext generates a Context field of the middleware struct and a WithContext method
+	// returning a shallow copy with another context, e.g. to create a middleware per request.
+	// The copies can't be synchronized, so it conflicts with Sync, Count and LastError.
+	StructContext bool
+	// Builder generates a <Type>MiddlewareBuilder with a chainable With<Method> method per method setting its middleware
+	// and a Build method wrapping a value in the built middleware
+	Builder bool
+	// FunctionalOptions generates a wrap function taking functional options setting the middleware
+	// instead of a middleware struct
+	FunctionalOptions bool
+	// Timing generates a WithTiming method setting the middleware of all methods to measure their duration
+	Timing bool
+	// Tracing generates a WithTracing method setting the middleware of all methods to run their calls in an OpenTelemetry span
+	Tracing bool
+	// Logging generates a WithLogging method setting the middleware of all methods to log their calls using log/slog
+	Logging bool
+	// Metrics generates a <Type>Metrics interface and a WithMetrics method setting the middleware of all methods
+	// to report their calls, durations and errors to it
+	Metrics bool
+}
+
+// Generate generates the middleware for the interfaces configured in opts
+// and writes the formatted code to w
+func Generate(w io.Writer, opts Options) error {
+	generators, err := generate(opts)
+	if err != nil {
+		return err
+	}
+
+	generic := false
+	for _, g := range generators {
+		generic = generic || g.typeParams != ""
+	}
+
+	src := new(bytes.Buffer)
+	generators[0].printHeader(src, nil, generic)
+	for _, g := range generators {
+		g.printBody(src)
+	}
+
+	// Format the code and add imports
+	res, err := formatCode(src.Bytes(), opts.Formatter)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(res)
+	return err
+}
+
+// File is a generated file
+type File struct {
+	// Name is the name of the file, relative to the directory of the package
+	Name string
+	// Content is the formatted code of the file
+	Content []byte
+}
+
+// MiddlewareFileSuffix returns the suffix of the names of the files generated for the package with the passed name,
+// which are test files if it is an external test package
+func MiddlewareFileSuffix(packageName string) string {
+	if strings.HasSuffix(packageName, "_test") {
+		return "_middleware_test.go"
+	}
+	return "_middleware.go"
+}
+
+// GenerateFiles generates the middleware for the interfaces configured in opts like Generate,
+// but splits it into multiple files to avoid merge conflicts in large interfaces.
+// Per interface, the middleware struct, the wrap function and the handler types are placed in <type>_middleware.go
+// and the implementation of every method in <type>_<method>_middleware.go, with the names in lower case.
+// The files of an external test package, e.g. foo_test, end in _middleware_test.go instead.
+func GenerateFiles(opts Options) ([]File, error) {
+	generators, err := generate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	addFile := func(g *generator, name string, print func(w io.Writer)) error {
+		body := new(bytes.Buffer)
+		print(body)
+		used, err := usedPackageNames(g.packageName, body.Bytes())
+		if err != nil {
+			return err
+		}
+
+		src := new(bytes.Buffer)
+		g.printHeader(src, used, g.typeParams != "")
+		src.Write(body.Bytes())
+		res, err := formatCode(src.Bytes(), opts.Formatter)
+		if err != nil {
+			return err
+		}
+		files = append(files, File{Name: strings.ToLower(name) + MiddlewareFileSuffix(g.packageName), Content: res})
+		return nil
+	}
+
+	for _, g := range generators {
+		err := addFile(g, g.targetName, func(w io.Writer) {
+			g.printShared(w)
+			w.Write(g.helperMethods.Bytes())
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range g.methods {
+			if err := addFile(g, g.targetName+"_"+m.name, func(w io.Writer) { w.Write(m.code.Bytes()) }); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// generate loads the package and generates the middleware for the interfaces configured in opts,
+// returning a generator holding the generated code per interface
+func generate(opts Options) ([]*generator, error) {
+	if len(opts.TypeNames) == 0 {
+		return nil, errors.New("no type name supplied")
+	}
+	for _, typeName := range opts.TypeNames {
+		if strings.TrimSpace(typeName) == "" {
+			return nil, errors.New("type names must not be empty")
+		}
+	}
+
+	if opts.WrappedField != "" && !token.IsIdentifier(opts.WrappedField) {
+		return nil, fmt.Errorf("provided wrapped field name '%s' is not a valid identifier", opts.WrappedField)
+	}
+	if opts.PackageName != "" && !token.IsIdentifier(opts.PackageName) {
+		return nil, fmt.Errorf("provided package name '%s' is not a valid identifier", opts.PackageName)
+	}
+
+	if opts.Receiver != "" && !token.IsIdentifier(opts.Receiver) {
+		return nil, fmt.Errorf("provided receiver name '%s' is not a valid identifier", opts.Receiver)
+	}
+
+	if opts.BuildConstraint != "" {
+		if _, err := constraint.Parse("//go:build " + opts.BuildConstraint); err != nil {
+			return nil, fmt.Errorf("provided build constraint '%s' is invalid - %w", opts.BuildConstraint, err)
+		}
+	}
+
+	if opts.Sync && opts.ValueReceivers {
+		return nil, errors.New("synchronized middleware can't be copied, so it requires pointer receivers")
+	}
+	if opts.Count && opts.ValueReceivers {
+		return nil, errors.New("middleware counting calls can't be copied, so it requires pointer receivers")
+	}
+	if opts.LastError && opts.ValueReceivers {
+		return nil, errors.New("middleware recording errors can't be copied, so it requires pointer receivers")
+	}
+
+	if opts.StructContext && (opts.Sync || opts.Count || opts.LastError) {
+		return nil, errors.New("middleware with a context is copied by WithContext, so it can't be synchronized")
+	}
+
+	if len(opts.Include) > 0 && len(opts.Exclude) > 0 {
+		return nil, errors.New("methods can't be both included and excluded, only one of them may be supplied")
+	}
+
+	// The package is only loaded once and shared by all generators
+	start := time.Now()
+	var pack *packages.Package
+	var err error
+	if opts.Source != nil {
+		pack, err = loadSource(opts.Source)
+	} else {
+		// The syntax of the package of the generated code is always needed to recognize previously generated files
+		pack, err = loadPackageFunc(opts.Dir, ".", opts.BuildTags, true)
+	}
+	if err != nil {
+		return nil, err
+	}
+	opts.logf("loaded package %s for %d types in %v", pack.PkgPath, len(opts.TypeNames), time.Since(start).Round(time.Millisecond))
+
+	// The packages declaring the interfaces are loaded once per import path
+	targetPacks := make(map[string]*packages.Package)
+	loadTarget := func(path string) (*packages.Package, error) {
+		if path == "" {
+			return pack, nil
+		}
+		if targetPack, ok := targetPacks[path]; ok {
+			return targetPack, nil
+		}
+		start := time.Now()
+		targetPack, err := loadPackageFunc(opts.Dir, path, opts.BuildTags, !opts.NoDoc)
+		if err != nil {
+			return nil, err
+		}
+		opts.logf("loaded package %s in %v", targetPack.PkgPath, time.Since(start).Round(time.Millisecond))
+		targetPacks[path] = targetPack
+		return targetPack, nil
+	}
+
+	imports := make(map[string]string)
+	declared := make(map[string]string)
+
+	packageName := pack.Name
+	if opts.PackageName != "" {
+		packageName = opts.PackageName
+	}
+
+	generators := make([]*generator, len(opts.TypeNames))
+	for i, name := range opts.TypeNames {
+		path, name, err := splitTargetPath(strings.TrimSpace(name), opts.PackagePath)
+		if err != nil {
+			return nil, err
+		}
+		targetPack, err := loadTarget(path)
+		if err != nil {
+			return nil, err
+		}
+
+		g := &generator{
+			opts:          &opts,
+			p:             pack,
+			packageName:   packageName,
+			targetPackage: targetPack,
+			imports:       imports,
+			declared:      declared,
+		}
+		if err := g.init(name); err != nil {
+			return nil, err
+		}
+
+		// Generate the actual code
+		if err := g.generateWrapperCode(); err != nil {
+			return nil, err
+		}
+
+		generators[i] = g
+	}
+
+	// Selected methods not declared by any of the interfaces are most likely misspelled
+	for _, name := range opts.Exclude {
+		if !declaresMethod(generators, name) && opts.Warnings != nil {
+			fmt.Fprintf(opts.Warnings, "middlewarer: warning: excluded method %s is not declared by any of the interfaces\n", name)
+		}
+	}
+	for _, name := range opts.Include {
+		if !declaresMethod(generators, name) && opts.Warnings != nil {
+			fmt.Fprintf(opts.Warnings, "middlewarer: warning: included method %s is not declared by any of the interfaces\n", name)
+		}
+	}
+
+	// Methods with results have to wait for them, so they are called synchronously
+	if opts.Async && opts.Warnings != nil {
+		for _, g := range generators {
+			var synchronous []string
+			for _, m := range g.methods {
+				if m.intercepted && !m.async {
+					synchronous = append(synchronous, m.name)
+				}
+			}
+			if len(synchronous) > 0 {
+				fmt.Fprintf(opts.Warnings, "middlewarer: warning: methods %s of %s return values, so they are always called synchronously\n", strings.Join(synchronous, ", "), g.targetName)
+			}
+		}
+	}
+
+	// Only the last error of methods returning several errors is handled by the generated code
+	if opts.Warnings != nil {
+		for _, g := range generators {
+			var ambiguous []string
+			for _, m := range g.methods {
+				if errorResultCount(m.sig) > 1 {
+					ambiguous = append(ambiguous, m.name)
+				}
+			}
+			if len(ambiguous) > 0 {
+				fmt.Fprintf(opts.Warnings, "middlewarer: warning: methods %s of %s return several errors, only the last one is treated as their error result\n", strings.Join(ambiguous, ", "), g.targetName)
+			}
+		}
+	}
+
+	// Unexported types of other packages can't be referenced by the generated code
+	if opts.Warnings != nil {
+		for _, g := range generators {
+			if names := g.inaccessibleTypes(); len(names) > 0 {
+				fmt.Fprintf(opts.Warnings, "middlewarer: warning: methods of %s reference the unexported types %s, which can't be referenced from package %s\n", g.targetName, strings.Join(names, ", "), g.packageName)
+			}
+		}
+	}
+
+	if opts.Metadata != nil {
+		if err := writeMetadata(opts.Metadata, generators); err != nil {
+			return nil, err
+		}
+	}
+
+	return generators, nil
+}
+
+// splitTargetPath splits the passed target into the import path of the package declaring it and the target
+// within that package. The interfaces of a composite target, e.g. Name=io#Reader+io#Writer, are split individually,
+// so they have to be declared in the same package.
+func splitTargetPath(target, defaultPath string) (string, string, error) {
+	if !strings.Contains(target, "+") {
+		return splitTypePath(target, defaultPath)
+	}
+
+	prefix := ""
+	list := target
+	if name, parts, named := strings.Cut(target, "="); named {
+		prefix, list = name+"=", parts
+	}
+	partNames := strings.Split(list, "+")
+	var path string
+	for i, partName := range partNames {
+		partPath, name, err := splitTypePath(strings.TrimSpace(partName), defaultPath)
+		if err != nil {
+			return "", "", err
+		}
+		if i > 0 && partPath != path {
+			return "", "", fmt.Errorf("composite '%s' combines interfaces of different packages, %s and %s, only interfaces of the same package can be combined", target, packageDescription(path), packageDescription(partPath))
+		}
+		path, partNames[i] = partPath, name
+	}
+	return path, prefix + strings.Join(partNames, "+"), nil
+}
+
+// packageDescription returns the passed import path for error messages, describing the empty path as the current package
+func packageDescription(path string) string {
+	if path == "" {
+		return "the package in the current directory"
+	}
+	return path
+}
+
+// splitTypePath splits the passed type name of the form import/path#Type into the import path
+// of the package declaring the type and the type name. Type names without an import path
+// are declared in the package with the passed default path.
+func splitTypePath(typeName, defaultPath string) (string, string, error) {
+	path, name, qualified := strings.Cut(typeName, "#")
+	if !qualified {
+		return defaultPath, typeName, nil
+	}
+	if path == "" || name == "" || strings.Contains(name, "#") {
+		return "", "", fmt.Errorf("type name '%s' is malformed, expected import/path#Type", typeName)
+	}
+	return path, name, nil
+}
+
+// logf logs the passed message to the verbose log, if one is set
+func (opts *Options) logf(format string, args ...any) {
+	if opts.Verbose != nil {
+		fmt.Fprintf(opts.Verbose, "middlewarer: "+format+"\n", args...)
+	}
+}
+
+// intercepts returns whether calls to the named method pass through middleware
+func (opts *Options) intercepts(method string) bool {
+	if len(opts.Include) > 0 {
+		return contains(opts.Include, method)
+	}
+	return !contains(opts.Exclude, method)
+}
+
+// declaresMethod returns whether any of the targets of the passed generators declares the named method
+func declaresMethod(generators []*generator, name string) bool {
+	for _, g := range generators {
+		for _, m := range g.methods {
+			if m.name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains returns whether the passed list contains s
+func contains(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,512 @@
+package middlewarer
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata with the generated code")
+
+// assertGolden compares the generated code to the golden file with the passed name in testdata,
+// updating the golden file instead if the tests run with -update
+func assertGolden(t testing.TB, name, generated string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(generated), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run the tests with -update to create it - %v", err)
+	}
+	if string(golden) != generated {
+		t.Errorf("generated code differs from %s, run the tests with -update if the change is intended\n%s", path, generated)
+	}
+}
+
+// generateSource generates the middleware configured in opts for the interfaces declared in src,
+// failing the test if it can't be generated
+func generateSource(t testing.TB, src string, opts Options) string {
+	t.Helper()
+
+	opts.Source = strings.NewReader(src)
+	out := new(bytes.Buffer)
+	if err := Generate(out, opts); err != nil {
+		t.Fatalf("failed to generate middleware for %v - %v", opts.TypeNames, err)
+	}
+	return out.String()
+}
+
+// typeCheck type-checks the passed files of a package, usually the source declaring the interfaces
+// and the generated code, failing the test if they don't compile
+func typeCheck(t testing.TB, codes ...string) {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, code := range codes {
+		file, err := parser.ParseFile(fset, "", code, 0)
+		if err != nil {
+			t.Fatalf("failed to parse code - %v\n%s", err, code)
+		}
+		files = append(files, file)
+	}
+
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check(files[0].Name.Name, fset, files, nil); err != nil {
+		t.Fatalf("generated code doesn't compile - %v\n%s", err, codes[len(codes)-1])
+	}
+}
+
+// storeSource declares the interface whose generated middleware is run by the tests,
+// storeImpl implements it in their test files
+const storeSource = `package p
+
+type Store interface {
+	Get(key string) (string, error)
+	Put(key, value string)
+	Close() error
+}
+`
+
+const storeImpl = `
+type store struct{ closeErr error }
+
+func (store) Get(key string) (string, error) { return "value of " + key, nil }
+
+func (store) Put(key, value string) {}
+
+func (s store) Close() error { return s.closeErr }
+`
+
+// traceStub is a subset of the OpenTelemetry trace API, which the generated code is compiled against
+// so the tests don't depend on the module
+const traceStub = `package trace
+
+import "context"
+
+type SpanStartOption interface{}
+
+type SpanEndOption interface{}
+
+type EventOption interface{}
+
+type Span interface {
+	End(options ...SpanEndOption)
+	RecordError(err error, options ...EventOption)
+}
+
+type Tracer interface {
+	Start(ctx context.Context, spanName string, opts ...SpanStartOption) (context.Context, Span)
+}
+`
+
+// runGenerated generates the middleware configured in opts for the interfaces declared in src
+// and runs the passed test file of package p against it with go test in a temporary module,
+// failing the test if the tests of the file fail. It is skipped in short mode or if go isn't installed.
+func runGenerated(t *testing.T, src string, opts Options, test string) {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping go test of the generated code in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("skipping go test of the generated code, go isn't installed")
+	}
+
+	generated := generateSource(t, src, opts)
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":          "module p\n\ngo 1.21\n",
+		"p.go":            src,
+		"p_middleware.go": generated,
+		"p_test.go":       test,
+	}
+	if strings.Contains(generated, `"go.opentelemetry.io/otel/trace"`) {
+		files["go.mod"] += "\nrequire go.opentelemetry.io/otel v0.0.0\n\nreplace go.opentelemetry.io/otel => ./otel\n"
+		files["otel/go.mod"] = "module go.opentelemetry.io/otel\n\ngo 1.21\n"
+		files["otel/trace/trace.go"] = traceStub
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goBin, "test", "-count=1", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go test of the generated code failed - %v\n%s\n%s", err, out, generated)
+	}
+}
+
+// assertContains fails the test if the generated code doesn't contain all of the passed snippets
+func assertContains(t testing.TB, generated string, snippets ...string) {
+	t.Helper()
+
+	for _, snippet := range snippets {
+		if !strings.Contains(generated, snippet) {
+			t.Errorf("generated code doesn't contain %q\n%s", snippet, generated)
+		}
+	}
+}
+
+const genericSource = `package p
+
+import "context"
+
+type Repository[K comparable, V any] interface {
+	Get(ctx context.Context, key K) (V, error)
+	Put(key K, value V)
+}
+`
+
+func TestGenericMiddleware(t *testing.T) {
+	test := `package p
+
+import (
+	"context"
+	"testing"
+)
+
+type repository map[string]int
+
+func (r repository) Get(ctx context.Context, key string) (int, error) { return r[key], nil }
+
+func (r repository) Put(key string, value int) { r[key] = value }
+
+func TestGenericMiddleware(t *testing.T) {
+	wrapped := repository{}
+	mw := NewRepositoryMiddleware[string, int](wrapped)
+	mw.PutMiddleware = func(next PutHandler[string, int]) PutHandler[string, int] {
+		return func(key string, value int) {
+			next(key, value*2)
+		}
+	}
+	var repo Repository[string, int] = mw
+
+	repo.Put("k", 21)
+	if value, _ := repo.Get(context.Background(), "k"); value != 42 || wrapped["k"] != 42 {
+		t.Errorf("Get returned %d after Put of 21, want the doubled value 42 stored by the middleware", value)
+	}
+}
+`
+	runGenerated(t, genericSource, Options{TypeNames: []string{"Repository"}}, test)
+}
+
+func TestGenerateGeneric(t *testing.T) {
+	generated := generateSource(t, genericSource, Options{TypeNames: []string{"Repository"}})
+	typeCheck(t, genericSource, generated)
+	assertContains(t, generated,
+		"type RepositoryMiddleware[K comparable, V any] struct",
+		"func WrapRepository[K comparable, V any](toWrap Repository[K, V], wrapper RepositoryMiddleware[K, V]) Repository[K, V]",
+		"type GetHandler[K comparable, V any] func(ctx context.Context, key K) (V, error)",
+		"func (r *RepositoryMiddleware[K, V]) Put(a0 K, a1 V)",
+	)
+}
+
+const featureSource = `package p
+
+import (
+	"context"
+	"io"
+)
+
+type Service interface {
+	Fetch(ctx context.Context, id int) (string, error)
+	Store(key, value string) error
+	Log(format string, args ...any)
+	Stream() <-chan []byte
+	io.Closer
+}
+
+type Store[T any] interface {
+	Put(v T) error
+	All() []T
+	Notify(levels ...T)
+}
+`
+
+// TestGenerateFeatures is a smoke test type-checking the code generated for every feature,
+// each combined with the features changing the method bodies.
+// The behaviour of the generated code is tested next to the generation of each feature.
+func TestGenerateFeatures(t *testing.T) {
+	features := map[string]Options{
+		"default":      {},
+		"names":        {ParamNames: true},
+		"value":        {ValueReceivers: true},
+		"chain":        {Chain: true},
+		"ctx":          {Context: true},
+		"recover":      {Recover: true},
+		"toggle":       {Toggle: true},
+		"async":        {Async: true},
+		"abortable":    {Abortable: true},
+		"hooks":        {Hooks: true},
+		"around":       {Around: true},
+		"sync":         {Sync: true, Getters: true},
+		"count":        {Count: true, LastError: true},
+		"options":      {FunctionalOptions: true},
+		"builder":      {Builder: true},
+		"byname":       {ByName: true},
+		"helpers":      {Timing: true, Metrics: true},
+		"logging":      {Logging: true},
+		"context":      {StructContext: true},
+		"exclude":      {Exclude: []string{"Close", "All"}},
+		"wrappedField": {WrappedField: "inner"},
+		"everything": {
+			ParamNames: true, Chain: true, Context: true, Recover: true, Toggle: true, Async: true, Abortable: true,
+			Hooks: true, Around: true, Sync: true, Getters: true, Count: true, LastError: true, FunctionalOptions: true,
+			Builder: true, ByName: true, Timing: true, Metrics: true, Logging: true,
+		},
+	}
+
+	for name, opts := range features {
+		t.Run(name, func(t *testing.T) {
+			opts.TypeNames = []string{"Service", "Store"}
+			generated := generateSource(t, featureSource, opts)
+			typeCheck(t, featureSource, generated)
+		})
+	}
+}
+
+func TestMiddlewareFileSuffix(t *testing.T) {
+	tests := map[string]string{
+		"p":      "_middleware.go",
+		"p_test": "_middleware_test.go",
+		"test":   "_middleware.go",
+	}
+	for packageName, want := range tests {
+		if suffix := MiddlewareFileSuffix(packageName); suffix != want {
+			t.Errorf("MiddlewareFileSuffix(%q) = %q, want %q", packageName, suffix, want)
+		}
+	}
+}
+
+func TestSplitTargetPath(t *testing.T) {
+	tests := []struct {
+		target, path, name string
+	}{
+		{"Foo", "", "Foo"},
+		{"io#Reader", "io", "Reader"},
+		{"A+B", "", "A+B"},
+		{"RW=io#Reader+io#Writer", "io", "RW=Reader+Writer"},
+		{"io#Reader + io#Writer", "io", "Reader+Writer"},
+	}
+	for _, test := range tests {
+		path, name, err := splitTargetPath(test.target, "")
+		if err != nil || path != test.path || name != test.name {
+			t.Errorf("splitTargetPath(%q) = %q, %q, %v, want %q, %q", test.target, path, name, err, test.path, test.name)
+		}
+	}
+
+	for _, target := range []string{"RW=io#Reader+Writer", "io#Reader+bufio#Writer", "RW=io#Reader+#Writer", "io#"} {
+		if _, _, err := splitTargetPath(target, ""); err == nil {
+			t.Errorf("splitTargetPath(%q) didn't fail", target)
+		}
+	}
+}
+
+func TestGenerateQualifiedComposite(t *testing.T) {
+	src := "package p\n"
+	generated := generateSource(t, src, Options{TypeNames: []string{"RW=io#Reader+io#Writer"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"// RW combines io.Reader, io.Writer\ntype RW interface {",
+		"func WrapRW(",
+	)
+}
+
+// TestSampleUpToDate asserts that the middleware of the sample package, whose tests run the generated code,
+// matches the code generated by the current generator, run go generate in internal/sample to update it
+func TestSampleUpToDate(t *testing.T) {
+	samples := map[string]Options{
+		"store_middleware.go": {
+			TypeNames: []string{"Store"}, Sync: true, Getters: true, LastError: true, Toggle: true, ByName: true,
+			Args: []string{"-byname", "-getters", "-lasterr", "-output=store_middleware.go", "-sync", "-toggle", "-type=Store"},
+		},
+		"counter_middleware.go": {
+			TypeNames: []string{"Counter"},
+			Args:      []string{"-output=counter_middleware.go", "-type=Counter"},
+		},
+	}
+
+	for file, opts := range samples {
+		opts.Dir = filepath.Join("..", "internal", "sample")
+		sample, err := os.ReadFile(filepath.Join(opts.Dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated := new(bytes.Buffer)
+		if err := Generate(generated, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(generated.Bytes(), sample) {
+			t.Errorf("%s is outdated, run go generate in %s", file, opts.Dir)
+		}
+	}
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	opts := Options{
+		TypeNames: []string{"Service", "Store"}, Chain: true, Toggle: true, Hooks: true, Sync: true, Getters: true,
+		Count: true, LastError: true, Builder: true, Timing: true, Metrics: true,
+	}
+	first := generateSource(t, featureSource, opts)
+	for i := 0; i < 10; i++ {
+		if generated := generateSource(t, featureSource, opts); generated != first {
+			t.Fatalf("generating twice produced different code\n%s\nwant\n%s", generated, first)
+		}
+	}
+}
+
+func TestGenerateFiles(t *testing.T) {
+	src := `package p
+
+type Store interface {
+	Get(key string) (string, error)
+	Put(key, value string) error
+}
+`
+	tests := map[string]string{
+		"p":      "_middleware.go",
+		"p_test": "_middleware_test.go",
+	}
+	for packageName, suffix := range tests {
+		files, err := GenerateFiles(Options{TypeNames: []string{"Store"}, PackageName: packageName, Args: []string{"-split", "-type=Store"}, Source: strings.NewReader(src)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		codes := []string{src}
+		for _, file := range files {
+			names = append(names, file.Name)
+			codes = append(codes, string(file.Content))
+		}
+		want := []string{"store" + suffix, "store_get" + suffix, "store_put" + suffix}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Errorf("generated files %v, want %v", names, want)
+		}
+
+		// The files of the external test package import the package declaring the interface
+		if packageName == "p" {
+			typeCheck(t, codes...)
+			for _, file := range files {
+				assertGolden(t, filepath.Join("split", file.Name), string(file.Content))
+			}
+		}
+	}
+}
+
+func TestGenerateImportedInterface(t *testing.T) {
+	src := "package p\n"
+	for _, opts := range []Options{{PackagePath: "io", TypeNames: []string{"ReadCloser"}}, {TypeNames: []string{"io#ReadCloser"}}} {
+		generated := generateSource(t, src, opts)
+		typeCheck(t, src, generated)
+		assertContains(t, generated,
+			"func WrapReadCloser(toWrap io.ReadCloser, wrapper ReadCloserMiddleware) io.ReadCloser {",
+			"type ReadHandler func(p []byte) (n int, err error)",
+		)
+	}
+}
+
+// importerFunc implements types.Importer by calling itself
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+func TestGeneratePackageName(t *testing.T) {
+	src := `package p
+
+type Store interface {
+	Get(key string) (string, error)
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Store"}, PackageName: "other"})
+	assertContains(t, generated,
+		"package other\n",
+		"\"p\"",
+		"func WrapStore(toWrap p.Store, wrapper StoreMiddleware) p.Store {",
+	)
+
+	// The generated code imports the package declaring the interface
+	fset := token.NewFileSet()
+	srcFile, err := parser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("p", fset, []*ast.File{srcFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genFile, err := parser.ParseFile(fset, "", generated, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importerFunc(func(path string) (*types.Package, error) {
+		if path == "p" {
+			return pkg, nil
+		}
+		return importer.Default().Import(path)
+	})}
+	if _, err := conf.Check("other", fset, []*ast.File{genFile}, nil); err != nil {
+		t.Errorf("generated code doesn't compile - %v\n%s", err, generated)
+	}
+}
+
+func TestGenerateMultiple(t *testing.T) {
+	src := `package p
+
+import "context"
+
+type Reader interface {
+	Read(ctx context.Context) ([]byte, error)
+}
+
+type Writer interface {
+	Write(ctx context.Context, b []byte) error
+}
+
+type Other interface {
+	Read() error
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Reader", " Writer"}})
+	typeCheck(t, src, generated)
+	if strings.Count(generated, "\"context\"") != 1 {
+		t.Errorf("generated code doesn't import context once\n%s", generated)
+	}
+	assertContains(t, generated, "func WrapReader(", "func WrapWriter(")
+
+	// The handler types of methods with the same name collide
+	err := Generate(new(bytes.Buffer), Options{TypeNames: []string{"Reader", "Other"}, Source: strings.NewReader(src)})
+	if err == nil || !strings.Contains(err.Error(), "generated identifier 'ReadHandler' for Other.Read collides with the one for Reader.Read") {
+		t.Errorf("expected a collision of ReadHandler, got %v", err)
+	}
+}
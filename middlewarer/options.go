@@ -0,0 +1,104 @@
+package middlewarer
+
+import "fmt"
+
+// wrapOptionsFunctionFormat is the format string of the function returning a wrapped instance
+// of the passed interface, whose middleware is set by functional options
+// The arguments for the format string are:
+//
+//	[1]: The name of the option type
+//	[2]: The name of the middleware struct
+//	[3]: The type parameter list, empty if the interface is not generic
+//	[4]: The type arguments, empty if the interface is not generic
+//	[5]: The interface type, qualified if declared in another package
+//	[6]: The name of the wrap function
+//	[7]: The name of the constructor
+//	[8]: The operator turning the constructed pointer into the receiver type, * for value receivers
+const wrapOptionsFunctionFormat = `// %[6]s returns the passed %[5]s wrapped in the middleware set by the passed options
+func %[6]s%[3]s(toWrap %[5]s%[4]s, options ...%[1]s%[4]s) %[5]s%[4]s {
+	wrapper := %[7]s%[4]s(toWrap)
+	for _, option := range options {
+		option(wrapper)
+	}
+	return %[8]swrapper
+}
+
+// %[1]s sets middleware of a %[2]s
+type %[1]s%[3]s func(*%[2]s%[4]s)
+`
+
+// optionFormat is the format string of a function returning an option setting a field of the middleware struct
+// The arguments for the format string are:
+//
+//	[1]: The doc comment of the function
+//	[2]: The name of the function
+//	[3]: The type parameter list, empty if the interface is not generic
+//	[4]: The name of the parameter
+//	[5]: The type of the parameter
+//	[6]: The option type, including the type arguments
+//	[7]: The name of the receiver of the option
+//	[8]: The type of the middleware struct, including the type arguments
+//	[9]: The statement setting the field
+const optionFormat = `
+%[1]s
+func %[2]s%[3]s(%[4]s %[5]s) %[6]s {
+	return func(%[7]s *%[8]s) {
+		%[9]s
+	}
+}
+`
+
+// generateOptionType generates the option type and the wrap function applying the options
+func (g *generator) generateOptionType() {
+	dereference := ""
+	if g.opts.ValueReceivers {
+		dereference = "*"
+	}
+
+	g.optionTypeName = g.targetName + "Option"
+	if g.unexported {
+		g.optionTypeName = lowerFirst(g.optionTypeName)
+	}
+	g.declarePackageLevel(g.optionTypeName, g.targetName)
+
+	fmt.Fprintf(g.wrapFunction, wrapOptionsFunctionFormat,
+		g.optionTypeName,
+		g.structName,
+		g.typeParams,
+		g.typeArgs,
+		g.targetType,
+		g.wrapFuncName,
+		g.newFuncName,
+		dereference,
+	)
+}
+
+// generateOption generates a function named With<name> returning an option setting the passed field of the middleware struct.
+// If appends is set, the field is a slice to which the passed values are appended.
+func (g *generator) generateOption(name, field, param, paramType string, appends bool, origin string) {
+	name = "With" + name
+	if g.unexported {
+		name = lowerFirst(name)
+	}
+	g.declarePackageLevel(name, origin)
+
+	doc := fmt.Sprintf("// %s returns an option setting %s", name, field)
+	set := fmt.Sprintf("%s.%s = %s", g.receiver, field, param)
+	if appends {
+		doc = fmt.Sprintf("// %s returns an option appending the passed middleware to %s", name, field)
+		set = fmt.Sprintf("%[1]s.%[2]s = append(%[1]s.%[2]s, %[3]s...)", g.receiver, field, param)
+		paramType = "..." + paramType
+	}
+
+	fmt.Fprintf(g.wrapFunction, optionFormat,
+		doc,
+		name,
+		g.typeParams,
+		param,
+		paramType,
+		g.optionTypeName+g.typeArgs,
+		g.receiver,
+		g.structName+g.typeArgs,
+		set,
+	)
+}
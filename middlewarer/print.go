@@ -0,0 +1,194 @@
+package middlewarer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strings"
+)
+
+// printHeader writes the header of the generated file to the provided io.Writer.
+// It has to be written once before the bodies of all generators of a file.
+// Only the packages for which used returns true are imported, all of them if it is nil.
+// If the file contains generic code, it is constrained to Go 1.18 or newer.
+func (g *generator) printHeader(w io.Writer, used func(name string) bool, generic bool) {
+	if g.opts.LicenseHeader != "" {
+		fmt.Fprintf(w, "%s\n\n", strings.TrimRight(g.opts.LicenseHeader, "\n"))
+	}
+	// Build constraints have to precede all other comments and be followed by a blank line
+	if expr := buildConstraint(g.opts.BuildConstraint, generic); expr != "" {
+		fmt.Fprintf(w, "//go:build %s\n\n", expr)
+	}
+	if !g.opts.NoBanner {
+		fmt.Fprintf(w, "// Code generated by \"middlewarer %s\"; DO NOT EDIT.\n", strings.Join(g.opts.Args, " "))
+	}
+	fmt.Fprintf(w, "package %s\n", g.packageName)
+	fmt.Fprintln(w)
+	g.printImports(w, used)
+}
+
+// buildConstraint returns the expression of the build constraint of the generated file,
+// combining the passed constraint with go1.18 if the file contains generic code
+func buildConstraint(expr string, generic bool) string {
+	if !generic {
+		return expr
+	}
+	goVersion := &constraint.TagExpr{Tag: "go1.18"}
+	if expr == "" {
+		return goVersion.String()
+	}
+	// The constraint has been validated before
+	parsed, _ := constraint.Parse("//go:build " + expr)
+	return (&constraint.AndExpr{X: parsed, Y: goVersion}).String()
+}
+
+// printBody writes the generated code to the provided io.Writer
+func (g *generator) printBody(w io.Writer) {
+	g.printShared(w)
+	for _, m := range g.methods {
+		w.Write(m.code.Bytes())
+	}
+	fmt.Fprintln(w)
+	w.Write(g.helperMethods.Bytes())
+}
+
+// printShared writes the generated code not belonging to a single method to the provided io.Writer,
+// which is everything but the methods if they are split into their own files
+func (g *generator) printShared(w io.Writer) {
+	w.Write(g.wrapFunction.Bytes())
+	fmt.Fprintln(w)
+	w.Write(g.middlewareStruct.Bytes())
+	fmt.Fprintln(w)
+	w.Write(g.handlerFuncTypes.Bytes())
+	fmt.Fprintln(w)
+}
+
+// printImports writes the import declaration for the packages referenced by the generated code,
+// which are filtered by used if it isn't nil.
+// Like goimports, standard library packages are grouped before all other packages.
+func (g *generator) printImports(w io.Writer, used func(name string) bool) {
+	var std, other []string
+	for path, name := range g.imports {
+		if used != nil && !used(name) {
+			continue
+		}
+		spec := fmt.Sprintf("%q", path)
+		if name != importName(path) {
+			spec = name + " " + spec
+		}
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	if len(std) == 0 && len(other) == 0 {
+		return
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+
+	fmt.Fprintln(w, "import (")
+	for _, spec := range std {
+		fmt.Fprintf(w, "\t%s\n", spec)
+	}
+	if len(std) > 0 && len(other) > 0 {
+		fmt.Fprintln(w)
+	}
+	for _, spec := range other {
+		fmt.Fprintf(w, "\t%s\n", spec)
+	}
+	fmt.Fprintln(w, ")")
+	fmt.Fprintln(w)
+}
+
+// importName returns the name a package is imported as by default,
+// which is the last element of its import path
+func importName(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// typeStringQuantifier is to be used as the quantifier for calls to [types.TypeString]
+// It records every package it qualifies, so that it can be imported by the generated code.
+func (g *generator) typeStringQuantifier(p *types.Package) string {
+	if g.isLocal(p) {
+		return ""
+	}
+	return g.importAs(p.Path(), p.Name())
+}
+
+// usedPackageNames returns a function reporting whether the package with the passed name
+// is referenced by the passed generated code of the package with the passed name
+func usedPackageNames(packageName string, body []byte) (func(name string) bool, error) {
+	src := append([]byte("package "+packageName+"\n"), body...)
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated code - %w", err)
+	}
+
+	used := qualifiers(file)
+	return func(name string) bool { return used[name] }, nil
+}
+
+// qualifiers returns the names qualifying selector expressions of the passed file which aren't declared in it,
+// which are the names of the packages it references
+func qualifiers(file *ast.File) map[string]bool {
+	used := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		// Package names are qualifiers which aren't resolved to any declaration of the file
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+	return used
+}
+
+// isLocal returns whether the generated code is part of the passed package,
+// which is the case if the package is the one the generator was invoked in and the package name wasn't changed
+func (g *generator) isLocal(p *types.Package) bool {
+	return p.Path() == g.p.PkgPath && g.packageName == g.p.Name
+}
+
+// literalImports are the packages referenced by name in the generated code itself,
+// so their names can't be used for any other package
+var literalImports = map[string]string{
+	"fmt":  "fmt",
+	"time": "time",
+}
+
+// importAs records the package with the passed path and name to be imported by the generated code.
+// It returns the name the package is referenced by, which is suffixed with a number
+// if the name is already taken by another package.
+func (g *generator) importAs(path, name string) string {
+	if imported, ok := g.imports[path]; ok {
+		return imported
+	}
+
+	unique := name
+	for i := 2; g.importNameTaken(path, unique); i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	g.imports[path] = unique
+	return unique
+}
+
+// importNameTaken returns whether the passed name is already used for a package other than the one with the passed path
+func (g *generator) importNameTaken(path, name string) bool {
+	if literalPath, ok := literalImports[name]; ok && literalPath != path {
+		return true
+	}
+	for importedPath, imported := range g.imports {
+		if imported == name && importedPath != path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package middlewarer
+
+import (
+	"strings"
+	"testing"
+)
+
+const headerSource = `package p
+
+type Pinger interface {
+	Ping() error
+}
+`
+
+func TestGenerateHeader(t *testing.T) {
+	tests := map[string]struct {
+		opts   Options
+		header string
+	}{
+		"banner": {
+			Options{Args: []string{"-type=Pinger"}},
+			"// Code generated by \"middlewarer -type=Pinger\"; DO NOT EDIT.\npackage p\n",
+		},
+		"constraint": {
+			Options{Args: []string{"-type=Pinger"}, BuildConstraint: "linux && !race"},
+			"//go:build linux && !race\n\n// Code generated by \"middlewarer -type=Pinger\"; DO NOT EDIT.\npackage p\n",
+		},
+		"license": {
+			Options{Args: []string{"-type=Pinger"}, BuildConstraint: "linux", LicenseHeader: "// Copyright 2024 The Authors.\n// SPDX-License-Identifier: MIT\n\n\n"},
+			"// Copyright 2024 The Authors.\n// SPDX-License-Identifier: MIT\n\n//go:build linux\n\n// Code generated by \"middlewarer -type=Pinger\"; DO NOT EDIT.\npackage p\n",
+		},
+		"noBanner": {
+			Options{Args: []string{"-type=Pinger"}, NoBanner: true},
+			"package p\n",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.opts.TypeNames = []string{"Pinger"}
+			generated := generateSource(t, headerSource, test.opts)
+			typeCheck(t, headerSource, generated)
+			if !strings.HasPrefix(generated, test.header) {
+				t.Errorf("generated code doesn't start with %q\n%s", test.header, generated)
+			}
+		})
+	}
+}
+
+func TestGenerateInvalidBuildConstraint(t *testing.T) {
+	opts := Options{TypeNames: []string{"Pinger"}, BuildConstraint: "linux &&", Source: strings.NewReader(headerSource)}
+	if err := Generate(new(strings.Builder), opts); err == nil || !strings.Contains(err.Error(), "build constraint") {
+		t.Errorf("expected an invalid build constraint error, got %v", err)
+	}
+}
+
+func TestBuildConstraint(t *testing.T) {
+	tests := []struct {
+		expr    string
+		generic bool
+		want    string
+	}{
+		{"", false, ""},
+		{"linux", false, "linux"},
+		{"", true, "go1.18"},
+		{"linux", true, "linux && go1.18"},
+		{"linux || darwin", true, "(linux || darwin) && go1.18"},
+	}
+	for _, test := range tests {
+		if expr := buildConstraint(test.expr, test.generic); expr != test.want {
+			t.Errorf("buildConstraint(%q, %t) = %q, want %q", test.expr, test.generic, expr, test.want)
+		}
+	}
+
+	generated := generateSource(t, genericSource, Options{TypeNames: []string{"Repository"}})
+	if !strings.HasPrefix(generated, "//go:build go1.18\n\n") {
+		t.Errorf("generic code isn't constrained to Go 1.18\n%s", generated)
+	}
+}
+
+func TestGenerateSamePackageNames(t *testing.T) {
+	src := `package p
+
+import (
+	htmltemplate "html/template"
+	"text/template"
+)
+
+type Renderer interface {
+	Render(text *template.Template, html *htmltemplate.Template) error
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Renderer"}})
+	typeCheck(t, src, generated)
+	assertContains(t, generated,
+		"template2 \"html/template\"\n\t\"text/template\"",
+		"func (r *RendererMiddleware) Render(a0 *template.Template, a1 *template2.Template) error {",
+	)
+}
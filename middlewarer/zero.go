@@ -0,0 +1,49 @@
+package middlewarer
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+)
+
+// zeroValue returns the expression of the zero value of the passed type in the generated code
+func (g *generator) zeroValue(t types.Type) string {
+	t = unalias(t)
+
+	// Type parameters may stand for any type, so there is no literal of their zero value
+	if param, ok := t.(*types.TypeParam); ok {
+		return fmt.Sprintf("*new(%s)", param.Obj().Name())
+	}
+
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		}
+		// unsafe.Pointer
+		return "nil"
+	case *types.Pointer, *types.Interface, *types.Map, *types.Slice, *types.Chan, *types.Signature:
+		return "nil"
+	}
+
+	// Structs and arrays
+	return types.TypeString(t, g.typeStringQuantifier) + "{}"
+}
+
+// zeroReturn returns the statement returning the zero values of all results of the passed method
+func (g *generator) zeroReturn(m *method) string {
+	if m.sig.Results().Len() == 0 {
+		return "return"
+	}
+
+	values := make([]string, m.sig.Results().Len())
+	for i := range values {
+		values[i] = g.zeroValue(m.sig.Results().At(i).Type())
+	}
+	return "return " + strings.Join(values, ", ")
+}
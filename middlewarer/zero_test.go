@@ -0,0 +1,120 @@
+package middlewarer
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const zeroSource = `package p
+
+import (
+	"io"
+	"time"
+	"unsafe"
+)
+
+type (
+	Point   struct{ X, Y int }
+	Celsius float64
+	Name    = string
+	Pair[T any] struct{ A, B T }
+)
+
+var (
+	vBool      bool
+	vInt       int
+	vComplex   complex128
+	vRune      rune
+	vString    string
+	vCelsius   Celsius
+	vDuration  time.Duration
+	vName      Name
+	vPointer   unsafe.Pointer
+	vPtr       *Point
+	vSlice     []byte
+	vMap       map[string]int
+	vChan      <-chan int
+	vFunc      func() error
+	vError     error
+	vReader    io.Reader
+	vAny       any
+	vStruct    Point
+	vAnonymous struct{ A int }
+	vArray     [2]int
+	vPair      Pair[int]
+)
+
+func Generic[T any](v T) {}
+`
+
+func TestZeroValue(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", zeroSource, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := (&types.Config{Importer: importer.Default()}).Check("p", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &generator{p: &packages.Package{PkgPath: "p", Name: "p", Types: pkg}, packageName: "p", imports: make(map[string]string)}
+
+	tests := map[string]string{
+		"vBool":      "false",
+		"vInt":       "0",
+		"vComplex":   "0",
+		"vRune":      "0",
+		"vString":    `""`,
+		"vCelsius":   "0",
+		"vDuration":  "0",
+		"vName":      `""`,
+		"vPointer":   "nil",
+		"vPtr":       "nil",
+		"vSlice":     "nil",
+		"vMap":       "nil",
+		"vChan":      "nil",
+		"vFunc":      "nil",
+		"vError":     "nil",
+		"vReader":    "nil",
+		"vAny":       "nil",
+		"vStruct":    "Point{}",
+		"vAnonymous": "struct{A int}{}",
+		"vArray":     "[2]int{}",
+		"vPair":      "Pair[int]{}",
+	}
+	for name, want := range tests {
+		if zero := g.zeroValue(pkg.Scope().Lookup(name).Type()); zero != want {
+			t.Errorf("zeroValue of %s = %s, want %s", name, zero, want)
+		}
+	}
+
+	param := pkg.Scope().Lookup("Generic").Type().(*types.Signature).TypeParams().At(0)
+	if zero := g.zeroValue(param); zero != "*new(T)" {
+		t.Errorf("zeroValue of a type parameter = %s, want *new(T)", zero)
+	}
+}
+
+func TestGenerateZeroValues(t *testing.T) {
+	src := `package p
+
+import (
+	"io"
+	"time"
+)
+
+type Point struct{ X, Y int }
+
+type Sensor interface {
+	Read() (Point, [2]int, time.Duration, io.Reader, string, bool, error)
+}
+`
+	generated := generateSource(t, src, Options{TypeNames: []string{"Sensor"}, Toggle: true})
+	typeCheck(t, src, generated)
+	assertContains(t, generated, `return Point{}, [2]int{}, 0, nil, "", false, nil`)
+}